@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/c-bata/rtmp"
 )
@@ -17,9 +21,23 @@ func main() {
 	flag.StringVar(&addr, "addr", ":1935", `TCP address to listen on, ":1935" if empty`)
 	flag.Parse()
 
+	server := &rtmp.Server{Addr: addr}
+	go func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+		<-sigc
+
+		log.Printf("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown Error: %s", err)
+		}
+	}()
+
 	log.Printf("Serving RTMP on %s (rev-%s)", addr, revision)
-	err := rtmp.ListenAndServe(addr)
-	if err != nil {
+	err := server.ListenAndServe()
+	if err != nil && err != rtmp.ErrServerClosed {
 		log.Printf("Got Error: %s", err)
 		os.Exit(1)
 	}
@@ -3,17 +3,19 @@ package rtmp
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
-	"log"
 )
 
 var (
 	errUnknownFMT           = errors.New("unknown fmt")
 	errInvalidChunkStreamID = errors.New("invalid chunk stream id")
-	errNoPreceedingChunk    = errors.New("basic header fmt is 3 but no preceeding chunk")
+	errNoPreceedingChunk    = errors.New("basic header fmt is not 0 but no preceeding chunk on the chunk stream")
 )
 
+// Timestamps and timestamp deltas which are greater than or equal to maxTimestamp
+// are sent in the extended timestamp field, and the field in the message header is set to maxTimestamp.
+const maxTimestamp uint32 = 16777215
+
 // Chunk Header
 
 type ChunkHeader struct {
@@ -33,19 +35,28 @@ func genChunkHeader(ch *ChunkHeader) ([]byte, error) {
 	}
 	x := append(bh, mh...)
 
-	if ch.MessageHeader.Timestamp >= 16777215 {
-		y := make([]byte, 4)
-		binary.BigEndian.PutUint32(y, ch.MessageHeader.Timestamp)
-		x = append(x, y...)
-	} else if ch.MessageHeader.TimestampDelta >= 16777215 {
+	extendedTimestamp := ch.ExtendedTimestamp // Type 3 chunks repeat the one of the preceding chunk.
+	switch ch.BasicHeader.FMT {
+	case 0:
+		extendedTimestamp = 0
+		if ch.MessageHeader.Timestamp >= maxTimestamp {
+			extendedTimestamp = ch.MessageHeader.Timestamp
+		}
+	case 1, 2:
+		extendedTimestamp = 0
+		if ch.MessageHeader.TimestampDelta >= maxTimestamp {
+			extendedTimestamp = ch.MessageHeader.TimestampDelta
+		}
+	}
+	if extendedTimestamp != 0 {
 		y := make([]byte, 4)
-		binary.BigEndian.PutUint32(y, ch.MessageHeader.TimestampDelta)
+		binary.BigEndian.PutUint32(y, extendedTimestamp)
 		x = append(x, y...)
 	}
 	return x, nil
 }
 
-func readChunkHeader(br io.Reader, oldHeaders []*ChunkHeader) (*ChunkHeader, int, error) {
+func readChunkHeader(br io.Reader, ctx *chunkStreamContext) (*ChunkHeader, int, error) {
 	chLen := 0
 	bh, bhLen, err := readBasicHeader(br)
 	if err != nil {
@@ -53,7 +64,8 @@ func readChunkHeader(br io.Reader, oldHeaders []*ChunkHeader) (*ChunkHeader, int
 	}
 	chLen += bhLen
 
-	mh, mhLen, err := readMessageHeader(br, bh, oldHeaders)
+	cs := ctx.streams[bh.ChunkStreamID]
+	mh, mhLen, err := readMessageHeader(br, bh, cs)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -64,7 +76,18 @@ func readChunkHeader(br io.Reader, oldHeaders []*ChunkHeader) (*ChunkHeader, int
 		MessageHeader: mh,
 	}
 
-	if mh.Timestamp == 16777215 || mh.TimestampDelta == 16777215 {
+	// The extended timestamp field is present if the timestamp (delta) field is 0xFFFFFF.
+	// Type 3 chunks have it if the preceding chunk on the same chunk stream has it.
+	var extended bool
+	switch bh.FMT {
+	case 0:
+		extended = mh.Timestamp == maxTimestamp
+	case 1, 2:
+		extended = mh.TimestampDelta == maxTimestamp
+	case 3:
+		extended = cs.extended
+	}
+	if extended {
 		x := make([]byte, 4)
 		_, err := io.ReadFull(br, x)
 		if err != nil {
@@ -72,7 +95,20 @@ func readChunkHeader(br io.Reader, oldHeaders []*ChunkHeader) (*ChunkHeader, int
 		}
 		ch.ExtendedTimestamp = binary.BigEndian.Uint32(x)
 		chLen += 4
+
+		switch bh.FMT {
+		case 0:
+			mh.Timestamp = ch.ExtendedTimestamp
+		case 1, 2:
+			mh.TimestampDelta = ch.ExtendedTimestamp
+		}
+	}
+
+	if bh.FMT == 1 || bh.FMT == 2 {
+		// The timestamp wraps around at 2^32 milliseconds (about 49.7 days).
+		mh.Timestamp = cs.header.Timestamp + mh.TimestampDelta
 	}
+	ctx.update(bh, mh)
 	return ch, chLen, nil
 }
 
@@ -108,10 +144,6 @@ func readBasicHeader(br io.Reader) (*BasicHeader, int, error) {
 		return nil, 0, err
 	}
 	x := xx[0]
-	fmt.Printf("basic header first byte: %v\n", x)
-	if x == 255 {
-		panic("oops")
-	}
 
 	h := new(BasicHeader)
 	h.FMT = uint8(x) >> 6
@@ -169,11 +201,11 @@ type MessageHeader struct {
 func genMessageHeader(mh *MessageHeader, fmt int) ([]byte, error) {
 	timestamp := mh.Timestamp
 	timestampDelta := mh.TimestampDelta
-	if timestamp > 16777215 {
-		timestamp = 16777215
+	if timestamp > maxTimestamp {
+		timestamp = maxTimestamp
 	}
-	if timestampDelta > 16777215 {
-		timestampDelta = 16777215
+	if timestampDelta > maxTimestamp {
+		timestampDelta = maxTimestamp
 	}
 
 	switch fmt {
@@ -233,8 +265,15 @@ func genMessageHeader(mh *MessageHeader, fmt int) ([]byte, error) {
 	}
 }
 
-func readMessageHeader(br io.Reader, bh *BasicHeader, oldHeaders []*ChunkHeader) (*MessageHeader, int, error) {
+// readMessageHeader reads the message header and resolves the fields which are omitted
+// by fmt 1, 2 and 3 from cs, the state of the chunk stream. cs is nil if no chunk
+// was received on the chunk stream yet.
+// The timestamp of fmt 1 and 2 is resolved by readChunkHeader after reading the extended timestamp.
+func readMessageHeader(br io.Reader, bh *BasicHeader, cs *chunkStream) (*MessageHeader, int, error) {
 	mh := new(MessageHeader)
+	if bh.FMT != 0 && (cs == nil || cs.header == nil) {
+		return nil, 0, errNoPreceedingChunk
+	}
 	switch bh.FMT {
 	case 0:
 		x := make([]byte, 11)
@@ -246,7 +285,6 @@ func readMessageHeader(br io.Reader, bh *BasicHeader, oldHeaders []*ChunkHeader)
 		mh.MessageLength = binary.BigEndian.Uint32(append([]byte{0x0}, x[3:6]...))
 		mh.MessageTypeID = x[6]
 		mh.MessageStreamID = binary.LittleEndian.Uint32(x[7:11])
-		log.Printf("message stream id=%v be=%v", x[7:11], mh.MessageStreamID)
 		return mh, 11, nil
 	case 1:
 		x := make([]byte, 7)
@@ -257,6 +295,7 @@ func readMessageHeader(br io.Reader, bh *BasicHeader, oldHeaders []*ChunkHeader)
 		mh.TimestampDelta = binary.BigEndian.Uint32(append([]byte{0x0}, x[:3]...))
 		mh.MessageLength = binary.BigEndian.Uint32(append([]byte{0x0}, x[3:6]...))
 		mh.MessageTypeID = x[6]
+		mh.MessageStreamID = cs.header.MessageStreamID
 		return mh, 7, nil
 	case 2:
 		x := make([]byte, 3)
@@ -264,24 +303,16 @@ func readMessageHeader(br io.Reader, bh *BasicHeader, oldHeaders []*ChunkHeader)
 		if err != nil {
 			return nil, 3, err
 		}
-		if len(oldHeaders) == 0 {
-			return nil, 0, errNoPreceedingChunk
-		}
-		*mh = *(oldHeaders[0].MessageHeader)
+		*mh = *cs.header
 		mh.TimestampDelta = binary.BigEndian.Uint32(append([]byte{0x0}, x...))
 		return mh, 3, nil
 	case 3:
-		var prevHeader *ChunkHeader
-		for _, h := range oldHeaders {
-			if h.BasicHeader.ChunkStreamID == bh.ChunkStreamID {
-				prevHeader = h
-				break
-			}
-		}
-		if prevHeader == nil {
-			return nil, 0, errNoPreceedingChunk
+		*mh = *cs.header
+		if cs.payload == nil {
+			// A type 3 chunk which begins a new message applies the same delta as the preceding chunk.
+			mh.TimestampDelta = cs.delta
+			mh.Timestamp = cs.header.Timestamp + cs.delta
 		}
-		*mh = *prevHeader.MessageHeader
 		return mh, 0, nil
 	default:
 		return nil, 0, errUnknownFMT
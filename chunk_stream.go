@@ -0,0 +1,134 @@
+package rtmp
+
+import (
+	"errors"
+	"io"
+)
+
+// DefaultChunkSize is the maximum chunk size until a Set Chunk Size message is received.
+const DefaultChunkSize = 128
+
+// maxPartialMessages is the maximum number of chunk streams which are receiving a message at once.
+// Peers interleave a few chunk streams, e.g. for audio, video and commands.
+const maxPartialMessages = 64
+
+var errTooManyPartialMessages = errors.New("too many chunk streams are receiving a message at once")
+
+// Message is a complete RTMP message which is reassembled from one or more chunks.
+type Message struct {
+	ChunkStreamID uint32
+	Timestamp     uint32
+	TypeID        MessageType
+	StreamID      uint32
+	Payload       []byte
+}
+
+// chunkStreamContext keeps the receiving state of all chunk streams in a connection.
+type chunkStreamContext struct {
+	streams map[uint32]*chunkStream
+	partial int // the number of chunk streams which have a partial payload.
+}
+
+func newChunkStreamContext() *chunkStreamContext {
+	return &chunkStreamContext{
+		streams: make(map[uint32]*chunkStream),
+	}
+}
+
+// get returns the state of the chunk stream. It is created if not exists.
+func (ctx *chunkStreamContext) get(csid uint32) *chunkStream {
+	cs, ok := ctx.streams[csid]
+	if !ok {
+		cs = &chunkStream{ctx: ctx}
+		ctx.streams[csid] = cs
+	}
+	return cs
+}
+
+// abort discards the partial payload of the message being received on the chunk stream.
+func (ctx *chunkStreamContext) abort(csid uint32) {
+	if cs, ok := ctx.streams[csid]; ok {
+		cs.setPayload(nil)
+	}
+}
+
+// update stores the message header which is resolved by readMessageHeader.
+func (ctx *chunkStreamContext) update(bh *BasicHeader, mh *MessageHeader) {
+	cs := ctx.get(bh.ChunkStreamID)
+	cs.header = mh
+	switch bh.FMT {
+	case 0:
+		// If a type 3 chunk follows a type 0 chunk, the timestamp delta is
+		// the same as the timestamp of the type 0 chunk.
+		cs.delta = mh.Timestamp
+		cs.extended = mh.Timestamp >= maxTimestamp
+	case 1, 2:
+		cs.delta = mh.TimestampDelta
+		cs.extended = mh.TimestampDelta >= maxTimestamp
+	}
+}
+
+// chunkStream keeps the receiving state of a single chunk stream.
+// Chunks of the different chunk streams can be interleaved, so the partial payload
+// is kept for each chunk stream until the whole message length is received.
+type chunkStream struct {
+	ctx      *chunkStreamContext
+	header   *MessageHeader // the last message header received on the chunk stream.
+	delta    uint32         // the timestamp delta applied by a type 3 chunk which begins a new message.
+	extended bool           // whether type 3 chunks have the extended timestamp field.
+	payload  []byte         // nil if no message is being received.
+}
+
+// readChunkPayload reads the chunk data which follows the chunk header ch.
+// It returns the message if the chunk completes it, otherwise it returns nil.
+func (cs *chunkStream) readChunkPayload(br io.Reader, ch *ChunkHeader, chunkSize uint32) (*Message, error) {
+	if cs.payload == nil || ch.BasicHeader.FMT != 3 {
+		// Only type 3 chunks can continue a message.
+		// Any other chunk begins a new message and drops the partial payload if exists.
+		// The capacity is limited to the chunk size, and grows as the chunks arrive,
+		// so that the peer can't make it allocate the message length without sending it.
+		capacity := ch.MessageHeader.MessageLength
+		if capacity > chunkSize {
+			capacity = chunkSize
+		}
+		if cs.payload == nil && cs.ctx != nil && cs.ctx.partial >= maxPartialMessages {
+			return nil, errTooManyPartialMessages
+		}
+		cs.setPayload(make([]byte, 0, capacity))
+	}
+
+	n := cs.header.MessageLength - uint32(len(cs.payload))
+	if n > chunkSize {
+		n = chunkSize
+	}
+	l := len(cs.payload)
+	cs.payload = append(cs.payload, make([]byte, n)...)
+	if _, err := io.ReadFull(br, cs.payload[l:]); err != nil {
+		return nil, err
+	}
+	if uint32(len(cs.payload)) < cs.header.MessageLength {
+		return nil, nil
+	}
+
+	msg := &Message{
+		ChunkStreamID: ch.BasicHeader.ChunkStreamID,
+		Timestamp:     cs.header.Timestamp,
+		TypeID:        MessageType(cs.header.MessageTypeID),
+		StreamID:      cs.header.MessageStreamID,
+		Payload:       cs.payload,
+	}
+	cs.setPayload(nil)
+	return msg, nil
+}
+
+// setPayload sets the partial payload and counts the chunk streams which have it.
+func (cs *chunkStream) setPayload(payload []byte) {
+	if cs.ctx != nil {
+		if cs.payload == nil && payload != nil {
+			cs.ctx.partial++
+		} else if cs.payload != nil && payload == nil {
+			cs.ctx.partial--
+		}
+	}
+	cs.payload = payload
+}
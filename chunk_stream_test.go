@@ -0,0 +1,151 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestReadChunkPayloadInterleaved(t *testing.T) {
+	video := make([]byte, 300)
+	for i := range video {
+		video[i] = byte(i)
+	}
+	command := []byte{0x01, 0x02, 0x03, 0x04, 0x05}
+
+	in := new(bytes.Buffer)
+	// 1st chunk of the video message on chunk stream 4 (fmt 0, 300 bytes)
+	in.Write([]byte{0x04, 0x00, 0x00, 0x0a, 0x00, 0x01, 0x2c, 0x09, 0x01, 0x00, 0x00, 0x00})
+	in.Write(video[:128])
+	// command message on chunk stream 3 (fmt 0, 5 bytes)
+	in.Write([]byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x14, 0x00, 0x00, 0x00, 0x00})
+	in.Write(command)
+	// 2nd and 3rd chunks of the video message (fmt 3)
+	in.Write([]byte{0xc4})
+	in.Write(video[128:256])
+	in.Write([]byte{0xc4})
+	in.Write(video[256:])
+	br := bufio.NewReader(in)
+
+	ctx := newChunkStreamContext()
+	var messages []*Message
+	for i := 0; i < 4; i++ {
+		ch, _, err := readChunkHeader(br, ctx)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		cs := ctx.get(ch.BasicHeader.ChunkStreamID)
+		msg, err := cs.readChunkPayload(br, ch, DefaultChunkSize)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("Should be 2 messages, but got %d", len(messages))
+	}
+	if messages[0].TypeID != MessageCommandAMF0 || bytes.Compare(messages[0].Payload, command) != 0 {
+		t.Errorf("Should be the command message, but got %#v", messages[0])
+	}
+	if messages[1].TypeID != MessageVideo || bytes.Compare(messages[1].Payload, video) != 0 {
+		t.Errorf("Should be the video message, but got %#v", messages[1])
+	}
+	if messages[1].Timestamp != 10 || messages[1].StreamID != 1 || messages[1].ChunkStreamID != 4 {
+		t.Errorf("Should be ts=10 stream=1 csid=4, but got %#v", messages[1])
+	}
+}
+
+func TestAbortDiscardsPartialPayload(t *testing.T) {
+	in := new(bytes.Buffer)
+	// 1st chunk of the video message on chunk stream 4 (fmt 0, 300 bytes)
+	in.Write([]byte{0x04, 0x00, 0x00, 0x0a, 0x00, 0x01, 0x2c, 0x09, 0x01, 0x00, 0x00, 0x00})
+	in.Write(bytes.Repeat([]byte{0x01}, 128))
+	// The next message begins with a type 3 chunk after the abort message.
+	in.Write([]byte{0xc4})
+	in.Write(bytes.Repeat([]byte{0x02}, 128))
+	br := bufio.NewReader(in)
+
+	ctx := newChunkStreamContext()
+	ch, _, err := readChunkHeader(br, ctx)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	msg, err := ctx.get(4).readChunkPayload(br, ch, DefaultChunkSize)
+	if err != nil || msg != nil {
+		t.Fatalf("Should be a partial message, but got %#v, %v", msg, err)
+	}
+
+	ctx.abort(4)
+
+	ch, _, err = readChunkHeader(br, ctx)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if ch.MessageHeader.Timestamp != 20 {
+		t.Errorf("Should be 20, but got %d", ch.MessageHeader.Timestamp)
+	}
+	msg, err = ctx.get(4).readChunkPayload(br, ch, DefaultChunkSize)
+	if err != nil || msg != nil {
+		t.Fatalf("Should be a partial message, but got %#v, %v", msg, err)
+	}
+	if bytes.Compare(ctx.get(4).payload, bytes.Repeat([]byte{0x02}, 128)) != 0 {
+		t.Errorf("Should not contain the aborted payload, but got %#v", ctx.get(4).payload)
+	}
+}
+
+// testPartialChunk returns the first chunk of a video message of the length on the chunk stream.
+func testPartialChunk(csid uint32, length uint32) []byte {
+	var b []byte
+	if csid < 64 {
+		b = []byte{byte(csid)}
+	} else {
+		b = []byte{0x00, byte(csid - 64)}
+	}
+	b = append(b, 0x00, 0x00, 0x00, byte(length>>16), byte(length>>8), byte(length), 0x09, 0x01, 0x00, 0x00, 0x00)
+	return append(b, make([]byte, DefaultChunkSize)...)
+}
+
+func TestReadChunkPayloadLimits(t *testing.T) {
+	in := new(bytes.Buffer)
+	in.Write(testPartialChunk(3, 0xffffff))
+	for csid := uint32(4); csid < 4+maxPartialMessages; csid++ {
+		in.Write(testPartialChunk(csid, 256))
+	}
+	br := bufio.NewReader(in)
+	ctx := newChunkStreamContext()
+
+	// The declared length isn't allocated before the payload arrives.
+	ch, _, err := readChunkHeader(br, ctx)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	cs := ctx.get(3)
+	if _, err = cs.readChunkPayload(br, ch, DefaultChunkSize); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if cap(cs.payload) > 2*DefaultChunkSize {
+		t.Errorf("Should be allocated as the chunks arrive, but got the capacity %d", cap(cs.payload))
+	}
+
+	for i := 1; i <= maxPartialMessages; i++ {
+		ch, _, err := readChunkHeader(br, ctx)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		_, err = ctx.get(ch.BasicHeader.ChunkStreamID).readChunkPayload(br, ch, DefaultChunkSize)
+		if i < maxPartialMessages && err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		} else if i == maxPartialMessages && err != errTooManyPartialMessages {
+			t.Errorf("Should be %s, but got %v", errTooManyPartialMessages, err)
+		}
+	}
+
+	// Aborting a partial message makes room for another one.
+	ctx.abort(3)
+	if ctx.partial != maxPartialMessages-1 {
+		t.Errorf("Should be %d, but got %d", maxPartialMessages-1, ctx.partial)
+	}
+}
@@ -11,7 +11,7 @@ func TestReadBasicHeader(t *testing.T) {
 	header := []byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0xb8, 0x14, 0x00, 0x00, 0x00, 0x00}
 	in := bufio.NewReader(bytes.NewBuffer(header))
 
-	actual, err := readBasicHeader(in)
+	actual, _, err := readBasicHeader(in)
 	if err != nil {
 		t.Errorf("Should be nil, but got %s", err)
 	}
@@ -43,7 +43,7 @@ func TestGenerateBasicHeader(t *testing.T) {
 func TestReadMessageHeader(t *testing.T) {
 	header := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0xb8, 0x14, 0x00, 0x00, 0x00, 0x00}
 	in := bufio.NewReader(bytes.NewBuffer(header))
-	actual, err := readMessageHeader(in, &BasicHeader{0, 0}, nil)
+	actual, _, err := readMessageHeader(in, &BasicHeader{0, 0}, nil)
 	if err != nil {
 		t.Errorf("Should be nil, but got %s", err)
 	}
@@ -84,7 +84,7 @@ func TestReadChunkHeader(t *testing.T) {
 	// message stream id (4 bytes) = 0000 0000 0000 0000 0000 0000 0000 0000
 	header := []byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0xb8, 0x14, 0x00, 0x00, 0x00, 0x00}
 	in := bufio.NewReader(bytes.NewBuffer(header))
-	actual, err := readChunkHeader(in, nil)
+	actual, _, err := readChunkHeader(in, newChunkStreamContext())
 	if err != nil {
 		t.Errorf("Should be nil, but got %s", err)
 	}
@@ -128,3 +128,97 @@ func TestGenerateChunkHeader(t *testing.T) {
 		t.Errorf("Should be %#v, but got %#v", actual, expected)
 	}
 }
+
+func TestReadChunkHeaderTimestampDelta(t *testing.T) {
+	in := []byte{
+		0x04, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x00, 0x09, 0x01, 0x00, 0x00, 0x00, // fmt 0: timestamp = 1000
+		0x44, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x09, // fmt 1: delta = 40
+		0x84, 0x00, 0x00, 0x21, // fmt 2: delta = 33
+		0xc4, // fmt 3: delta = 33
+	}
+	ctx := newChunkStreamContext()
+	br := bufio.NewReader(bytes.NewBuffer(in))
+	for _, expected := range []uint32{1000, 1040, 1073, 1106} {
+		ch, _, err := readChunkHeader(br, ctx)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if ch.MessageHeader.Timestamp != expected {
+			t.Errorf("Should be %d, but got %d", expected, ch.MessageHeader.Timestamp)
+		}
+		if ch.MessageHeader.MessageStreamID != 1 {
+			t.Errorf("Should be 1, but got %d", ch.MessageHeader.MessageStreamID)
+		}
+	}
+}
+
+func TestReadChunkHeaderUnknownChunkStream(t *testing.T) {
+	for _, in := range [][]byte{
+		{0x44, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x09},
+		{0x84, 0x00, 0x00, 0x21},
+		{0xc4},
+	} {
+		_, _, err := readChunkHeader(bytes.NewBuffer(in), newChunkStreamContext())
+		if err != errNoPreceedingChunk {
+			t.Errorf("Should be %s, but got %v", errNoPreceedingChunk, err)
+		}
+	}
+}
+
+func TestReadChunkHeaderExtendedTimestamp(t *testing.T) {
+	in := []byte{
+		// fmt 0: timestamp = 0x01000000 (extended)
+		0x04, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x09, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		// fmt 3: repeats the extended timestamp field
+		0xc4, 0x01, 0x00, 0x00, 0x00,
+		// fmt 2: delta = 0x01000000 (extended)
+		0x84, 0xff, 0xff, 0xff, 0x01, 0x00, 0x00, 0x00,
+		// fmt 1: delta = 40
+		0x44, 0x00, 0x00, 0x28, 0x00, 0x00, 0x00, 0x09,
+	}
+	ctx := newChunkStreamContext()
+	br := bufio.NewReader(bytes.NewBuffer(in))
+	for _, expected := range []uint32{0x01000000, 0x02000000, 0x03000000, 0x03000028} {
+		ch, _, err := readChunkHeader(br, ctx)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if ch.MessageHeader.Timestamp != expected {
+			t.Errorf("Should be %#x, but got %#x", expected, ch.MessageHeader.Timestamp)
+		}
+	}
+	if br.Buffered() != 0 {
+		t.Errorf("Should read all bytes, but %d bytes remain", br.Buffered())
+	}
+}
+
+func TestGenerateChunkHeaderExtendedTimestamp(t *testing.T) {
+	expected := []byte{0x04, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x09, 0x01, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff}
+	ch := &ChunkHeader{
+		BasicHeader: &BasicHeader{
+			FMT:           0,
+			ChunkStreamID: 4,
+		},
+		MessageHeader: &MessageHeader{
+			Timestamp:       16777215,
+			MessageTypeID:   9,
+			MessageStreamID: 1,
+		},
+	}
+	actual, err := genChunkHeader(ch)
+	if err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+	if bytes.Compare(actual, expected) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+
+	in := bufio.NewReader(bytes.NewBuffer(actual))
+	ch, _, err = readChunkHeader(in, newChunkStreamContext())
+	if err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+	if ch.MessageHeader.Timestamp != 16777215 {
+		t.Errorf("Should be 16777215, but got %d", ch.MessageHeader.Timestamp)
+	}
+}
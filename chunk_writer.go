@@ -0,0 +1,121 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+)
+
+// ChunkWriter writes messages to the underlying writer by splitting them into chunks.
+// The maximum chunk size is DefaultChunkSize until a Set Chunk Size message is written.
+type ChunkWriter struct {
+	w            io.Writer
+	chunkSize    uint32
+	chunkStreams *chunkStreamContext // headers previously sent on each chunk stream.
+}
+
+// NewChunkWriter returns a new ChunkWriter which writes chunks to w.
+func NewChunkWriter(w io.Writer) *ChunkWriter {
+	return &ChunkWriter{
+		w:            w,
+		chunkSize:    DefaultChunkSize,
+		chunkStreams: newChunkStreamContext(),
+	}
+}
+
+// ChunkSize returns the current outbound chunk size.
+func (cw *ChunkWriter) ChunkSize() uint32 {
+	return cw.chunkSize
+}
+
+// WriteMessage writes the message as a chunk with the smallest message header
+// followed by type 3 chunks. Each chunk carries at most ChunkSize bytes of the payload.
+// After a Set Chunk Size message is written, the following messages are split by the new chunk size.
+// A Set Chunk Size message with an invalid chunk size, e.g. 0, is not written and returns an error.
+func (cw *ChunkWriter) WriteMessage(msg *Message) error {
+	chunkSize := cw.chunkSize
+	if msg.TypeID == MessageSetChunkSize {
+		var err error
+		if chunkSize, err = readChunkSize(msg.Payload); err != nil {
+			return err
+		}
+	}
+
+	ch := &ChunkHeader{
+		BasicHeader: &BasicHeader{
+			ChunkStreamID: msg.ChunkStreamID,
+		},
+		MessageHeader: &MessageHeader{
+			Timestamp:       msg.Timestamp,
+			MessageLength:   uint32(len(msg.Payload)),
+			MessageTypeID:   uint8(msg.TypeID),
+			MessageStreamID: msg.StreamID,
+		},
+	}
+	ch.BasicHeader.FMT = cw.chooseFMT(ch.MessageHeader, cw.chunkStreams.streams[msg.ChunkStreamID])
+	cw.chunkStreams.update(ch.BasicHeader, ch.MessageHeader)
+	if cs := cw.chunkStreams.get(msg.ChunkStreamID); cs.extended {
+		// Used by type 3 chunks which repeat the extended timestamp field.
+		ch.ExtendedTimestamp = cs.delta
+	}
+
+	payload := msg.Payload
+	for {
+		header, err := genChunkHeader(ch)
+		if err != nil {
+			return err
+		}
+		if _, err = cw.w.Write(header); err != nil {
+			return err
+		}
+
+		n := uint32(len(payload))
+		if n > cw.chunkSize {
+			n = cw.chunkSize
+		}
+		if _, err = cw.w.Write(payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+		if len(payload) == 0 {
+			break
+		}
+		ch.BasicHeader.FMT = 3
+	}
+
+	cw.chunkSize = chunkSize
+	return nil
+}
+
+// chooseFMT returns the type of the message header which is the smallest one
+// to send mh after the last message header on the chunk stream cs.
+// It also sets the timestamp delta of mh if the type is not 0.
+func (cw *ChunkWriter) chooseFMT(mh *MessageHeader, cs *chunkStream) uint8 {
+	if cs == nil || cs.header == nil {
+		return 0
+	}
+	prev := cs.header
+	// The delta is calculated modulo 2^32 to keep using it across the wraparound of the timestamp.
+	delta := mh.Timestamp - prev.Timestamp
+	if mh.MessageStreamID != prev.MessageStreamID || int32(delta) < 0 {
+		return 0
+	}
+
+	mh.TimestampDelta = delta
+	switch {
+	case mh.MessageLength != prev.MessageLength || mh.MessageTypeID != prev.MessageTypeID:
+		return 1
+	case mh.TimestampDelta != cs.delta:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// encodeMessage returns the chunks of the message which is split by the default chunk size.
+func encodeMessage(msg *Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := NewChunkWriter(buf).WriteMessage(msg); err != nil {
+		return []byte{}, err
+	}
+	return buf.Bytes(), nil
+}
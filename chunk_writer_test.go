@@ -0,0 +1,181 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// readMessages reads all messages from in with the chunk size.
+func readMessages(t *testing.T, in []byte, chunkSize uint32) []*Message {
+	br := bufio.NewReader(bytes.NewBuffer(in))
+	ctx := newChunkStreamContext()
+	var messages []*Message
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return messages
+		}
+		ch, _, err := readChunkHeader(br, ctx)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		msg, err := ctx.get(ch.BasicHeader.ChunkStreamID).readChunkPayload(br, ch, chunkSize)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+}
+
+func TestChunkWriterSplitsMessage(t *testing.T) {
+	msg := &Message{
+		ChunkStreamID: 6,
+		Timestamp:     40,
+		TypeID:        MessageVideo,
+		StreamID:      1,
+		Payload:       bytes.Repeat([]byte{0xab}, 300),
+	}
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(buf)
+	if err := cw.WriteMessage(msg); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+
+	// 12 bytes of a type 0 chunk header + 2 bytes of type 3 chunk headers
+	if buf.Len() != 300+12+2 {
+		t.Errorf("Should be %d bytes, but got %d", 300+12+2, buf.Len())
+	}
+	x := buf.Bytes()
+	if x[12+128] != 0xc6 || x[12+128+1+128] != 0xc6 {
+		t.Errorf("Should be type 3 chunk headers, but got %#v and %#v", x[12+128], x[12+128+1+128])
+	}
+
+	messages := readMessages(t, x, DefaultChunkSize)
+	if len(messages) != 1 {
+		t.Fatalf("Should be 1 message, but got %d", len(messages))
+	}
+	if !reflect.DeepEqual(messages[0], msg) {
+		t.Errorf("Should be %#v, but got %#v", msg, messages[0])
+	}
+}
+
+func TestChunkWriterSetChunkSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(buf)
+	if err := cw.WriteMessage(setChunkSizeMessage(4096)); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if cw.ChunkSize() != 4096 {
+		t.Errorf("Should be 4096, but got %d", cw.ChunkSize())
+	}
+
+	buf.Reset()
+	msg := newCommandMessage(0, bytes.Repeat([]byte{0x05}, 1000))
+	if err := cw.WriteMessage(msg); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if buf.Len() != 1000+12 {
+		t.Errorf("Should be a single chunk, but got %d bytes", buf.Len())
+	}
+}
+
+func TestChunkWriterInvalidChunkSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(buf)
+	if err := cw.WriteMessage(setChunkSizeMessage(0)); err == nil {
+		t.Errorf("Should be an error for the chunk size 0")
+	}
+	if buf.Len() != 0 || cw.ChunkSize() != DefaultChunkSize {
+		t.Errorf("Should not be written, but got %d bytes and the chunk size %d", buf.Len(), cw.ChunkSize())
+	}
+	if err := cw.WriteMessage(newCommandMessage(0, []byte{0x05})); err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+}
+
+func TestChunkWriterCompressesHeaders(t *testing.T) {
+	messages := []*Message{
+		{ChunkStreamID: 4, Timestamp: 0, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 10)},
+		{ChunkStreamID: 4, Timestamp: 40, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 20)},
+		{ChunkStreamID: 4, Timestamp: 73, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 20)},
+		{ChunkStreamID: 4, Timestamp: 106, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 20)},
+		{ChunkStreamID: 4, Timestamp: 106, TypeID: MessageAudio, StreamID: 1, Payload: make([]byte, 20)},
+		{ChunkStreamID: 4, Timestamp: 106, TypeID: MessageAudio, StreamID: 2, Payload: make([]byte, 20)},
+		{ChunkStreamID: 4, Timestamp: 50, TypeID: MessageAudio, StreamID: 2, Payload: make([]byte, 20)},
+	}
+	expectedFMTs := []uint8{0, 1, 2, 3, 1, 0, 0}
+
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(buf)
+	var offsets []int
+	for _, msg := range messages {
+		offsets = append(offsets, buf.Len())
+		if err := cw.WriteMessage(msg); err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+	}
+
+	x := buf.Bytes()
+	for i, offset := range offsets {
+		if x[offset]>>6 != expectedFMTs[i] {
+			t.Errorf("message %d: fmt should be %d, but got %d", i, expectedFMTs[i], x[offset]>>6)
+		}
+	}
+
+	actual := readMessages(t, x, DefaultChunkSize)
+	if !reflect.DeepEqual(actual, messages) {
+		t.Errorf("Should be %#v, but got %#v", messages, actual)
+	}
+}
+
+func TestChunkWriterCompressedHeadersAcrossChunks(t *testing.T) {
+	// The type 3 chunks continuing a message must not be confused with the ones beginning a message.
+	messages := []*Message{
+		{ChunkStreamID: 6, Timestamp: 1000, TypeID: MessageVideo, StreamID: 1, Payload: bytes.Repeat([]byte{0x01}, 300)},
+		{ChunkStreamID: 6, Timestamp: 2000, TypeID: MessageVideo, StreamID: 1, Payload: bytes.Repeat([]byte{0x02}, 300)},
+		{ChunkStreamID: 6, Timestamp: 3000, TypeID: MessageVideo, StreamID: 1, Payload: bytes.Repeat([]byte{0x03}, 300)},
+	}
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(buf)
+	for _, msg := range messages {
+		if err := cw.WriteMessage(msg); err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+	}
+
+	actual := readMessages(t, buf.Bytes(), DefaultChunkSize)
+	if !reflect.DeepEqual(actual, messages) {
+		t.Errorf("Should be %#v, but got %#v", messages, actual)
+	}
+}
+
+func TestChunkWriterExtendedTimestamp(t *testing.T) {
+	messages := []*Message{
+		// type 0 chunk and type 3 chunks with the extended timestamp
+		{ChunkStreamID: 6, Timestamp: 16777215, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 300)},
+		// type 1 chunk with a small delta after the extended timestamp
+		{ChunkStreamID: 6, Timestamp: 16777255, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 20)},
+		// type 2 chunk with the extended delta
+		{ChunkStreamID: 6, Timestamp: 50000000, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 20)},
+		// type 3 chunk beginning a new message with the extended delta
+		{ChunkStreamID: 6, Timestamp: 83222745, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 20)},
+		// the timestamp wraps around
+		{ChunkStreamID: 6, Timestamp: 0xfffffff0, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 30)},
+		{ChunkStreamID: 6, Timestamp: 0x00000010, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 30)},
+	}
+	buf := new(bytes.Buffer)
+	cw := NewChunkWriter(buf)
+	for _, msg := range messages {
+		if err := cw.WriteMessage(msg); err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+	}
+
+	actual := readMessages(t, buf.Bytes(), DefaultChunkSize)
+	if !reflect.DeepEqual(actual, messages) {
+		t.Errorf("Should be %#v, but got %#v", messages, actual)
+	}
+}
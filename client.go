@@ -0,0 +1,521 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// DefaultPort is the port used when the URL doesn't have it.
+const DefaultPort = "1935"
+
+// clientChunkSize is the outbound chunk size set by the client after connecting.
+const clientChunkSize = 4096
+
+var errNotPublishing = errors.New("the connection is not publishing")
+
+// StatusError is the error response or the error status event sent by the server.
+type StatusError struct {
+	Command     string // the command which failed, e.g. "connect" or "publish".
+	Code        string // e.g. "NetConnection.Connect.Rejected" or "NetStream.Play.StreamNotFound".
+	Description string
+}
+
+func (e *StatusError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("%s failed: %s", e.Command, e.Code)
+	}
+	return fmt.Sprintf("%s failed: %s: %s", e.Command, e.Code, e.Description)
+}
+
+// ClientConn is an RTMP connection to a server, which publishes or plays the stream of the URL.
+//
+// The Write methods can be called from multiple goroutines,
+// but ReadMessage should be called from one goroutine.
+type ClientConn struct {
+	netconn net.Conn
+	bufr    *bufio.Reader
+	bufw    *bufio.Writer
+
+	app        string
+	tcURL      string
+	streamName string
+	streamID   uint32 // the message stream ID returned by createStream.
+	publishing bool
+
+	transactionID float64
+	// pending has the media and data messages received while waiting for a response.
+	pending []*Message
+
+	chunkSize    uint32 // the inbound chunk size.
+	chunkStreams *chunkStreamContext
+	chunkWriter  *ChunkWriter
+	wmu          sync.Mutex // guards chunkWriter and bufw.
+
+	// Acknowledgement
+	bytesReceived *countingReader
+	ackWindowSize uint32
+	lastAck       uint32
+}
+
+// Dial connects to the server of the URL, rtmp://host[:port]/app/stream, and sends a connect command.
+// The stream is published or played by Publish or Play. The query of the URL is a part of the stream name.
+func Dial(rawurl string) (*ClientConn, error) {
+	return DialContext(context.Background(), rawurl)
+}
+
+// DialContext is like Dial but the context limits the time to connect.
+func DialContext(ctx context.Context, rawurl string) (*ClientConn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rtmp" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	app, streamName := splitStreamPath(u.Path)
+	if app == "" {
+		return nil, fmt.Errorf("no app in %q", rawurl)
+	}
+	if u.RawQuery != "" {
+		streamName += "?" + u.RawQuery
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	c := newClientConn(nc)
+	c.app = app
+	c.tcURL = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + app}).String()
+	c.streamName = streamName
+
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { nc.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	if err = c.handshake(); err == nil {
+		err = c.connect()
+	}
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// splitStreamPath splits the path of the URL into the app and the stream name.
+// The app is the first element of the path, e.g. "/live/stream" is split into "live" and "stream".
+func splitStreamPath(p string) (app, streamName string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func newClientConn(nc net.Conn) *ClientConn {
+	cr := &countingReader{r: nc}
+	bufw := bufio.NewWriter(nc)
+	return &ClientConn{
+		netconn:       nc,
+		bufr:          bufio.NewReader(cr),
+		bufw:          bufw,
+		chunkSize:     DefaultChunkSize,
+		chunkStreams:  newChunkStreamContext(),
+		chunkWriter:   NewChunkWriter(bufw),
+		bytesReceived: cr,
+	}
+}
+
+// handshake runs the client side of the handshake. See the diagram of conn.handshake.
+func (c *ClientConn) handshake() error {
+	// >> C0, C1
+	c1 := newChunkC1S1(0)
+	c.bufw.Write(newChunkC0S0().Bytes())
+	c.bufw.Write(c1.Bytes())
+	if err := c.bufw.Flush(); err != nil {
+		return err
+	}
+
+	// << S0, S1
+	s0, err := readC0S0(c.bufr)
+	if err != nil {
+		return err
+	} else if s0.version != 3 {
+		return errors.New("unsupported rtmp version")
+	}
+	s1, err := readC1S1(c.bufr)
+	if err != nil {
+		return err
+	}
+
+	// >> C2
+	if _, err = c.bufw.Write(newChunkC2S2(s1).Bytes()); err != nil {
+		return err
+	}
+	if err = c.bufw.Flush(); err != nil {
+		return err
+	}
+
+	// << S2
+	// The echo is not verified because some servers don't echo C1 as it is.
+	_, err = readC2S2(c.bufr)
+	return err
+}
+
+func (c *ClientConn) connect() error {
+	transactionID := c.nextTransactionID()
+	if _, err := c.call("connect", transactionID, connectMessage(transactionID, c.app, c.tcURL)); err != nil {
+		return err
+	}
+	return c.writeMessages(setChunkSizeMessage(clientChunkSize))
+}
+
+// Publish sends createStream and publish commands for the stream of the URL,
+// and waits for NetStream.Publish.Start.
+func (c *ClientConn) Publish() error {
+	err := c.writeMessages(
+		callMessage(0, "releaseStream", c.nextTransactionID(), c.streamName),
+		callMessage(0, "FCPublish", c.nextTransactionID(), c.streamName),
+	)
+	if err != nil {
+		return err
+	}
+	if err := c.createStream(); err != nil {
+		return err
+	}
+	msg := callMessage(c.streamID, "publish", 0, c.streamName, "live")
+	if err := c.writeMessages(msg); err != nil {
+		return err
+	}
+	if err := c.waitStatus("publish", CodeNetStreamPublishStart); err != nil {
+		return err
+	}
+	c.publishing = true
+	return nil
+}
+
+// Play sends createStream and play commands for the stream of the URL,
+// and waits for NetStream.Play.Start. The messages of the stream are read by ReadMessage.
+func (c *ClientConn) Play() error {
+	if err := c.createStream(); err != nil {
+		return err
+	}
+	msg := callMessage(c.streamID, "play", 0, c.streamName)
+	if err := c.writeMessages(msg); err != nil {
+		return err
+	}
+	return c.waitStatus("play", CodeNetStreamPlayStart)
+}
+
+func (c *ClientConn) createStream() error {
+	transactionID := c.nextTransactionID()
+	values, err := c.call("createStream", transactionID, callMessage(0, "createStream", transactionID))
+	if err != nil {
+		return err
+	}
+	if len(values) < 4 {
+		return errors.New("invalid createStream response")
+	}
+	streamID, ok := values[3].(float64)
+	if !ok {
+		return errors.New("invalid createStream response")
+	}
+	c.streamID = uint32(streamID)
+	return nil
+}
+
+func (c *ClientConn) nextTransactionID() float64 {
+	c.transactionID++
+	return c.transactionID
+}
+
+// call writes the command message and returns the values of the _result response of the transaction.
+// The response is an error if it is _error.
+func (c *ClientConn) call(command string, transactionID float64, msg *Message) ([]interface{}, error) {
+	if err := c.writeMessages(msg); err != nil {
+		return nil, err
+	}
+	for {
+		resp, _, err := c.readCommand()
+		if err != nil {
+			return nil, err
+		}
+		if id, _ := resp[1].(float64); id != transactionID {
+			continue
+		}
+		switch resp[0] {
+		case "_result":
+			return resp, nil
+		case "_error":
+			return nil, statusError(command, resp)
+		}
+	}
+}
+
+// waitStatus waits for the onStatus command of the code on the message stream created by createStream.
+func (c *ClientConn) waitStatus(command string, code CommandCode) error {
+	for {
+		values, streamID, err := c.readCommand()
+		if err != nil {
+			return err
+		}
+		if values[0] != "onStatus" || streamID != c.streamID {
+			continue
+		}
+		level, got := statusInfo(values)
+		if got == string(code) {
+			return nil
+		} else if level == string(CommandLevelError) {
+			return statusError(command, values)
+		}
+	}
+}
+
+// readCommand returns the values and the message stream ID of the next AMF0 command message.
+// The media and data messages received while waiting are kept for ReadMessage.
+func (c *ClientConn) readCommand() ([]interface{}, uint32, error) {
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return nil, 0, err
+		}
+		if msg.TypeID != MessageCommandAMF0 {
+			c.pending = append(c.pending, msg)
+			continue
+		}
+		values, err := readCommandValues(msg.Payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(values) >= 2 {
+			return values, msg.StreamID, nil
+		}
+	}
+}
+
+// readCommandValues returns the values of the command message, which are the command name,
+// the transaction ID and the following arguments.
+func readCommandValues(payload []byte) ([]interface{}, error) {
+	var values []interface{}
+	buf := bytes.NewBuffer(payload)
+	for buf.Len() > 0 {
+		v, err := amf.ReadValue(buf)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// statusInfo returns the level and the code of the information object of onStatus, _result or _error.
+func statusInfo(values []interface{}) (level, code string) {
+	info := infoObject(values)
+	level, _ = info["level"].(string)
+	code, _ = info["code"].(string)
+	return level, code
+}
+
+func infoObject(values []interface{}) map[string]interface{} {
+	if len(values) < 4 {
+		return nil
+	}
+	switch obj := values[3].(type) {
+	case amf.Object:
+		return obj
+	case map[string]interface{}:
+		return obj
+	}
+	return nil
+}
+
+func statusError(command string, values []interface{}) *StatusError {
+	info := infoObject(values)
+	err := &StatusError{Command: command}
+	err.Code, _ = info["code"].(string)
+	err.Description, _ = info["description"].(string)
+	return err
+}
+
+// ReadMessage returns the next audio, video or data message of the stream played by Play.
+// It returns io.EOF when the stream finishes, i.e. the publisher stops publishing
+// or the recorded stream reaches the end, and a *StatusError when the server reports an error.
+func (c *ClientConn) ReadMessage() (*Message, error) {
+	if len(c.pending) > 0 {
+		msg := c.pending[0]
+		c.pending = c.pending[1:]
+		return msg, nil
+	}
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msg.TypeID {
+		case MessageAudio, MessageVideo, MessageDataAMF0:
+			return msg, nil
+		case MessageCommandAMF0:
+			values, err := readCommandValues(msg.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) == 0 || values[0] != "onStatus" || msg.StreamID != c.streamID {
+				continue
+			}
+			switch level, code := statusInfo(values); {
+			case code == string(CodeNetStreamPlayStop) || code == string(CodeNetStreamPlayUnpublish):
+				return nil, io.EOF
+			case level == string(CommandLevelError):
+				return nil, statusError("play", values)
+			}
+		}
+	}
+}
+
+// readMessage reads chunks until a message is assembled, and handles the protocol control messages
+// and the user control messages.
+func (c *ClientConn) readMessage() (*Message, error) {
+	for {
+		header, _, err := readChunkHeader(c.bufr, c.chunkStreams)
+		if err != nil {
+			return nil, err
+		}
+		cs := c.chunkStreams.get(header.BasicHeader.ChunkStreamID)
+		msg, err := cs.readChunkPayload(c.bufr, header, c.chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.acknowledge(); err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue
+		}
+
+		switch msg.TypeID {
+		case MessageSetChunkSize:
+			chunkSize, err := readChunkSize(msg.Payload)
+			if err != nil {
+				return nil, err
+			}
+			c.chunkSize = chunkSize
+		case MessageAbort:
+			if len(msg.Payload) >= 4 {
+				c.chunkStreams.abort(binary.BigEndian.Uint32(msg.Payload))
+			}
+		case MessageAcknowledgementWindowSize:
+			if len(msg.Payload) >= 4 {
+				c.ackWindowSize = binary.BigEndian.Uint32(msg.Payload)
+			}
+		case MessageUserControl:
+			e, err := ParseUserControlEvent(msg.Payload)
+			if err == nil && e.Type == UserControlPingRequest {
+				if err = c.writeMessages(userPingResponseMessage(e.Timestamp)); err != nil {
+					return nil, err
+				}
+			}
+		case MessageAcknowledgement, MessageSetPeerBandwidth:
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// acknowledge sends an Acknowledgement message to the server
+// if the bytes received since the last acknowledgement reach the window size.
+func (c *ClientConn) acknowledge() error {
+	if c.ackWindowSize == 0 {
+		return nil
+	}
+	received := c.bytesReceived.count()
+	if received-c.lastAck < c.ackWindowSize {
+		return nil
+	}
+	c.lastAck = received
+	return c.writeMessages(acknowledgementMessage(received))
+}
+
+// WriteAudio writes the payload of the audio message to the stream published by Publish.
+func (c *ClientConn) WriteAudio(timestamp uint32, payload []byte) error {
+	return c.WriteMessage(&Message{TypeID: MessageAudio, Timestamp: timestamp, Payload: payload})
+}
+
+// WriteVideo writes the payload of the video message to the stream published by Publish.
+func (c *ClientConn) WriteVideo(timestamp uint32, payload []byte) error {
+	return c.WriteMessage(&Message{TypeID: MessageVideo, Timestamp: timestamp, Payload: payload})
+}
+
+// WriteData writes the payload of the data message (AMF0), e.g. @setDataFrame or onMetaData,
+// to the stream published by Publish.
+func (c *ClientConn) WriteData(timestamp uint32, payload []byte) error {
+	return c.WriteMessage(&Message{TypeID: MessageDataAMF0, Timestamp: timestamp, Payload: payload})
+}
+
+// WriteMessage writes the audio, video or data message to the stream published by Publish.
+// The chunk stream ID and the message stream ID of msg are ignored.
+func (c *ClientConn) WriteMessage(msg *Message) error {
+	if !c.publishing {
+		return errNotPublishing
+	}
+	return c.writeMessages(playMessage(c.streamID, msg))
+}
+
+// writeMessages writes the messages through the chunk writer and flushes them.
+func (c *ClientConn) writeMessages(msgs ...*Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for _, msg := range msgs {
+		if err := c.chunkWriter.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+	return c.bufw.Flush()
+}
+
+// App returns the application name of the URL.
+func (c *ClientConn) App() string {
+	return c.app
+}
+
+// StreamName returns the stream name of the URL.
+func (c *ClientConn) StreamName() string {
+	return c.streamName
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *ClientConn) SetDeadline(t time.Time) error {
+	return c.netconn.SetDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+func (c *ClientConn) SetWriteDeadline(t time.Time) error {
+	return c.netconn.SetWriteDeadline(t)
+}
+
+// Close closes the connection. The server stops the stream published by the connection.
+func (c *ClientConn) Close() error {
+	return c.netconn.Close()
+}
@@ -0,0 +1,171 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+func TestSplitStreamPath(t *testing.T) {
+	for _, x := range []struct {
+		path, app, streamName string
+	}{
+		{"/live/stream", "live", "stream"},
+		{"/live/room/stream", "live", "room/stream"},
+		{"/live", "live", ""},
+		{"", "", ""},
+	} {
+		app, streamName := splitStreamPath(x.path)
+		if app != x.app || streamName != x.streamName {
+			t.Errorf("Should be %#v and %#v, but got %#v and %#v", x.app, x.streamName, app, streamName)
+		}
+	}
+}
+
+func TestClientPublishAndPlay(t *testing.T) {
+	h := newTestHandler()
+	srv := &Server{Handler: h, Streams: new(StreamHub)}
+	defer srv.Close()
+	addr := startTestServer(t, srv)
+
+	publisher, err := Dial("rtmp://" + addr + "/live/key?token=secret")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer publisher.Close()
+	publisher.SetDeadline(time.Now().Add(time.Second)) // fails if Publish.Start isn't on the stream.
+	if publisher.App() != "live" || publisher.StreamName() != "key?token=secret" {
+		t.Errorf("Should be live and key?token=secret, but got %#v and %#v", publisher.App(), publisher.StreamName())
+	}
+	if err = publisher.Publish(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	publisher.SetDeadline(time.Time{})
+	if publisher.streamID != 1 {
+		t.Errorf("Should be %#v, but got %#v", 1, publisher.streamID)
+	}
+	if key := <-h.published; key != "live/key?token=secret" {
+		t.Errorf("Should be %#v, but got %#v", "live/key?token=secret", key)
+	}
+
+	metadata := new(bytes.Buffer)
+	amf.WriteString(metadata, "@setDataFrame")
+	amf.WriteString(metadata, "onMetaData")
+	amf.WriteObject(metadata, amf.Object{"width": 1280.0})
+	sequenceHeader := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x1f, 0xff, 0xe0, 0x00}
+	keyframe := []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x01}
+	publisher.WriteData(0, metadata.Bytes())
+	publisher.WriteVideo(0, sequenceHeader)
+	publisher.WriteVideo(40, keyframe)
+	publisher.WriteAudio(40, []byte{0xaf, 0x01, 0x21})
+	<-h.audio
+
+	player, err := Dial("rtmp://" + addr + "/live/key?token=secret")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer player.Close()
+	if err = player.Play(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	var got []*Message
+	for len(got) < 4 {
+		msg, err := player.ReadMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg.TypeID == MessageDataAMF0 && !isMetadata(msg.Payload) {
+			continue // |RtmpSampleAccess
+		}
+		got = append(got, msg)
+	}
+	if m, err := ParseMetadata(got[0].Payload); err != nil || m.Width != 1280 {
+		t.Errorf("Should be onMetaData, but got %#v, %v", got[0], err)
+	}
+	for i, expected := range [][]byte{sequenceHeader, keyframe, {0xaf, 0x01, 0x21}} {
+		if !reflect.DeepEqual(got[i+1].Payload, expected) {
+			t.Errorf("Should be %#v, but got %#v", expected, got[i+1].Payload)
+		}
+	}
+	if got[2].Timestamp != 40 {
+		t.Errorf("Should be %#v, but got %#v", 40, got[2].Timestamp)
+	}
+
+	publisher.Close()
+	player.SetDeadline(time.Now().Add(time.Second))
+	if _, err = player.ReadMessage(); err != io.EOF {
+		t.Errorf("Should be %s, but got %v", io.EOF, err)
+	}
+}
+
+func TestClientWaitStatus(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := newClientConn(client)
+	defer c.Close()
+	c.streamID = 1
+
+	// Publish.Start on the other message stream is ignored.
+	go func() {
+		cw := NewChunkWriter(server)
+		cw.WriteMessage(onStatusMessage(2, CommandLevelStatus, CodeNetStreamPublishStart, ""))
+		cw.WriteMessage(onStatusMessage(1, CommandLevelError, CodeNetStreamPublishBadName, "in use"))
+	}()
+	var serr *StatusError
+	if err := c.waitStatus("publish", CodeNetStreamPublishStart); !errors.As(err, &serr) || serr.Code != string(CodeNetStreamPublishBadName) {
+		t.Errorf("Should be BadName, but got %v", err)
+	}
+}
+
+func TestClientInvalidChunkSize(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := newClientConn(client)
+	defer c.Close()
+
+	// Set Chunk Size 0, which ChunkWriter refuses to write.
+	go server.Write([]byte{0x02, 0, 0, 0, 0, 0, 4, byte(MessageSetChunkSize), 0, 0, 0, 0, 0, 0, 0, 0})
+	if _, err := c.readMessage(); err == nil {
+		t.Errorf("Should be an error for the chunk size 0")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := &Server{Handler: newTestHandler()}
+	defer srv.Close()
+	addr := startTestServer(t, srv)
+
+	var serr *StatusError
+	_, err := Dial("rtmp://" + addr + "/unknown/key")
+	if !errors.As(err, &serr) || serr.Command != "connect" || serr.Code != string(CodeNetConnectRejected) {
+		t.Errorf("Should be Rejected, but got %v", err)
+	}
+
+	c, err := Dial("rtmp://" + addr + "/live/missing")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer c.Close()
+	if err = c.Play(); !errors.As(err, &serr) || serr.Code != string(CodeNetStreamPlayStreamNotFound) {
+		t.Errorf("Should be StreamNotFound, but got %v", err)
+	}
+	if err = c.WriteVideo(0, nil); err != errNotPublishing {
+		t.Errorf("Should be %s, but got %v", errNotPublishing, err)
+	}
+
+	if _, err = Dial("http://" + addr + "/live/key"); err == nil {
+		t.Errorf("Should be an error for the scheme")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = DialContext(ctx, "rtmp://"+addr+"/live/key"); err == nil {
+		t.Errorf("Should be an error for the canceled context")
+	}
+}
@@ -0,0 +1,230 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The payload of a video message begins with the video tag header of FLV:
+//
+//  0                   1
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |Frame T|CodecID|AVCPacket Type | (AVCPacketType is only for AVC)
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// The payload of an audio message begins with the audio tag header of FLV:
+//
+//  0                   1
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |Format |R|S|T|AACPacket Type   | (AACPacketType is only for AAC)
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+
+// VideoFrameType is the frame type in the video tag header.
+type VideoFrameType uint8
+
+const (
+	VideoFrameKey             VideoFrameType = 1
+	VideoFrameInter           VideoFrameType = 2
+	VideoFrameDisposableInter VideoFrameType = 3
+	VideoFrameGenerated       VideoFrameType = 4
+	VideoFrameInfo            VideoFrameType = 5
+)
+
+// VideoCodec is the codec ID in the video tag header.
+type VideoCodec uint8
+
+const (
+	VideoCodecH263     VideoCodec = 2
+	VideoCodecScreen   VideoCodec = 3
+	VideoCodecVP6      VideoCodec = 4
+	VideoCodecVP6Alpha VideoCodec = 5
+	VideoCodecScreenV2 VideoCodec = 6
+	VideoCodecAVC      VideoCodec = 7
+)
+
+// AudioFormat is the sound format in the audio tag header.
+type AudioFormat uint8
+
+const (
+	AudioFormatPCM        AudioFormat = 0
+	AudioFormatADPCM      AudioFormat = 1
+	AudioFormatMP3        AudioFormat = 2
+	AudioFormatPCMLE      AudioFormat = 3
+	AudioFormatNellymoser AudioFormat = 6
+	AudioFormatG711A      AudioFormat = 7
+	AudioFormatG711U      AudioFormat = 8
+	AudioFormatAAC        AudioFormat = 10
+	AudioFormatSpeex      AudioFormat = 11
+)
+
+// isVideoKeyframe reports whether the payload of the video message is a keyframe.
+// The sequence header of AVC is not a keyframe though its frame type is.
+func isVideoKeyframe(payload []byte) bool {
+	return len(payload) > 0 && VideoFrameType(payload[0]>>4) == VideoFrameKey && !isVideoSequenceHeader(payload)
+}
+
+// isVideoSequenceHeader reports whether the payload of the video message is the AVC sequence header.
+func isVideoSequenceHeader(payload []byte) bool {
+	return len(payload) > 1 && VideoCodec(payload[0]&0x0f) == VideoCodecAVC && payload[1] == 0
+}
+
+// isAudioSequenceHeader reports whether the payload of the audio message is the AAC sequence header.
+func isAudioSequenceHeader(payload []byte) bool {
+	return len(payload) > 1 && AudioFormat(payload[0]>>4) == AudioFormatAAC && payload[1] == 0
+}
+
+var errInvalidSequenceHeader = errors.New("invalid sequence header")
+
+// VideoConfig is the decoder configuration parsed from the AVC sequence header,
+// which is the AVCDecoderConfigurationRecord defined in ISO/IEC 14496-15.
+type VideoConfig struct {
+	Codec                VideoCodec
+	Profile              uint8 // AVCProfileIndication, e.g. 66 (Baseline), 77 (Main) or 100 (High).
+	ProfileCompatibility uint8
+	Level                uint8 // AVCLevelIndication, e.g. 31 means Level 3.1.
+	NALUnitLength        int   // the size of the NAL unit length field in bytes.
+	SPS                  [][]byte
+	PPS                  [][]byte
+}
+
+// ParseVideoSequenceHeader parses the payload of the video message which is the AVC sequence header.
+//
+//	aligned(8) class AVCDecoderConfigurationRecord {
+//	    unsigned int(8) configurationVersion = 1;
+//	    unsigned int(8) AVCProfileIndication;
+//	    unsigned int(8) profile_compatibility;
+//	    unsigned int(8) AVCLevelIndication;
+//	    bit(6) reserved = '111111'b;
+//	    unsigned int(2) lengthSizeMinusOne;
+//	    bit(3) reserved = '111'b;
+//	    unsigned int(5) numOfSequenceParameterSets;
+//	    for (i=0; i< numOfSequenceParameterSets; i++) {
+//	        unsigned int(16) sequenceParameterSetLength;
+//	        bit(8*sequenceParameterSetLength) sequenceParameterSetNALUnit;
+//	    }
+//	    unsigned int(8) numOfPictureParameterSets;
+//	    for (i=0; i< numOfPictureParameterSets; i++) {
+//	        unsigned int(16) pictureParameterSetLength;
+//	        bit(8*pictureParameterSetLength) pictureParameterSetNALUnit;
+//	    }
+//	}
+func ParseVideoSequenceHeader(payload []byte) (*VideoConfig, error) {
+	// The record follows the video tag header, AVCPacketType and CompositionTime (24 bits).
+	if !isVideoSequenceHeader(payload) || len(payload) < 5 {
+		return nil, errInvalidSequenceHeader
+	}
+	record := payload[5:]
+	if len(record) < 6 || record[0] != 1 {
+		return nil, errInvalidSequenceHeader
+	}
+	config := &VideoConfig{
+		Codec:                VideoCodecAVC,
+		Profile:              record[1],
+		ProfileCompatibility: record[2],
+		Level:                record[3],
+		NALUnitLength:        int(record[4]&0x03) + 1,
+	}
+
+	var err error
+	rest := record[6:]
+	config.SPS, rest, err = readParameterSets(rest, int(record[5]&0x1f))
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < 1 {
+		return nil, errInvalidSequenceHeader
+	}
+	config.PPS, _, err = readParameterSets(rest[1:], int(rest[0]))
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func readParameterSets(b []byte, n int) ([][]byte, []byte, error) {
+	sets := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		if len(b) < 2 {
+			return nil, nil, errInvalidSequenceHeader
+		}
+		l := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+l {
+			return nil, nil, errInvalidSequenceHeader
+		}
+		sets = append(sets, b[2:2+l])
+		b = b[2+l:]
+	}
+	return sets, b, nil
+}
+
+// AudioConfig is the decoder configuration parsed from the AAC sequence header,
+// which is the AudioSpecificConfig defined in ISO/IEC 14496-3.
+type AudioConfig struct {
+	Format     AudioFormat
+	ObjectType uint8 // the audio object type, e.g. 2 means AAC LC.
+	SampleRate int   // in Hz.
+	Channels   int
+}
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ParseAudioSequenceHeader parses the payload of the audio message which is the AAC sequence header.
+//
+//	AudioSpecificConfig() {
+//	    audioObjectType;            5 bits (6 more bits if it is 31)
+//	    samplingFrequencyIndex;     4 bits
+//	    if (samplingFrequencyIndex == 0xf)
+//	        samplingFrequency;      24 bits
+//	    channelConfiguration;       4 bits
+//	    ...
+//	}
+func ParseAudioSequenceHeader(payload []byte) (*AudioConfig, error) {
+	if !isAudioSequenceHeader(payload) {
+		return nil, errInvalidSequenceHeader
+	}
+	br := &bitReader{b: payload[2:]}
+	config := &AudioConfig{Format: AudioFormatAAC}
+
+	objectType := br.read(5)
+	if objectType == 31 {
+		objectType = 32 + br.read(6)
+	}
+	config.ObjectType = uint8(objectType)
+
+	if index := br.read(4); index == 0xf {
+		config.SampleRate = int(br.read(24))
+	} else if int(index) < len(aacSampleRates) {
+		config.SampleRate = aacSampleRates[index]
+	} else {
+		return nil, errInvalidSequenceHeader
+	}
+	config.Channels = int(br.read(4))
+	if br.err != nil {
+		return nil, errInvalidSequenceHeader
+	}
+	return config, nil
+}
+
+// bitReader reads the bits in MSB first order.
+type bitReader struct {
+	b   []byte
+	pos int // in bits.
+	err error
+}
+
+func (br *bitReader) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if br.pos/8 >= len(br.b) {
+			br.err = errInvalidSequenceHeader
+			return 0
+		}
+		bit := (br.b[br.pos/8] >> (7 - uint(br.pos%8))) & 1
+		v = v<<1 | uint32(bit)
+		br.pos++
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseVideoSequenceHeader(t *testing.T) {
+	payload := []byte{
+		0x17, 0x00, 0x00, 0x00, 0x00, // video tag header, AVCPacketType and CompositionTime
+		0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1, // version, High profile, compatibility, level 3.1, lengthSizeMinusOne, 1 SPS
+		0x00, 0x04, 0x67, 0x64, 0x00, 0x1f, // SPS
+		0x01,                         // 1 PPS
+		0x00, 0x03, 0x68, 0xeb, 0xe3, // PPS
+	}
+	config, err := ParseVideoSequenceHeader(payload)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if config.Codec != VideoCodecAVC || config.Profile != 100 || config.Level != 31 || config.NALUnitLength != 4 {
+		t.Errorf("Should be AVC High@3.1, but got %#v", config)
+	}
+	if len(config.SPS) != 1 || bytes.Compare(config.SPS[0], []byte{0x67, 0x64, 0x00, 0x1f}) != 0 {
+		t.Errorf("Should be the SPS, but got %#v", config.SPS)
+	}
+	if len(config.PPS) != 1 || bytes.Compare(config.PPS[0], []byte{0x68, 0xeb, 0xe3}) != 0 {
+		t.Errorf("Should be the PPS, but got %#v", config.PPS)
+	}
+
+	if _, err = ParseVideoSequenceHeader(payload[:len(payload)-1]); err != errInvalidSequenceHeader {
+		t.Errorf("Should be %s, but got %v", errInvalidSequenceHeader, err)
+	}
+	if _, err = ParseVideoSequenceHeader([]byte{0x17, 0x01, 0x00, 0x00, 0x00}); err != errInvalidSequenceHeader {
+		t.Errorf("Should be %s, but got %v", errInvalidSequenceHeader, err)
+	}
+}
+
+func TestParseAudioSequenceHeader(t *testing.T) {
+	// AAC LC, 44100 Hz, 2 channels
+	config, err := ParseAudioSequenceHeader([]byte{0xaf, 0x00, 0x12, 0x10})
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	expected := AudioConfig{Format: AudioFormatAAC, ObjectType: 2, SampleRate: 44100, Channels: 2}
+	if *config != expected {
+		t.Errorf("Should be %#v, but got %#v", expected, *config)
+	}
+
+	if _, err = ParseAudioSequenceHeader([]byte{0xaf, 0x00, 0x12}); err != errInvalidSequenceHeader {
+		t.Errorf("Should be %s, but got %v", errInvalidSequenceHeader, err)
+	}
+}
+
+func TestIsVideoKeyframe(t *testing.T) {
+	for _, x := range []struct {
+		payload  []byte
+		expected bool
+	}{
+		{[]byte{0x17, 0x01}, true},
+		{[]byte{0x17, 0x00}, false}, // sequence header
+		{[]byte{0x27, 0x01}, false},
+		{[]byte{}, false},
+	} {
+		if actual := isVideoKeyframe(x.payload); actual != x.expected {
+			t.Errorf("Should be %v, but got %v: %#v", x.expected, actual, x.payload)
+		}
+	}
+}
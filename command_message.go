@@ -3,7 +3,6 @@ package rtmp
 import (
 	"bytes"
 	"fmt"
-	"math"
 
 	"github.com/zhangpeihao/goamf"
 )
@@ -19,8 +18,67 @@ const (
 	CodeNetConnectNetworkChange             = "NetConnection.Connect.NetworkChange"
 	CodeNetConnectRejected                  = "NetConnection.Connect.Rejected"
 	CodeNetConnectSuccess                   = "NetConnection.Connect.Success"
+
+	CodeNetStreamPublishStart       = "NetStream.Publish.Start"
+	CodeNetStreamPublishBadName     = "NetStream.Publish.BadName"
+	CodeNetStreamPlayStart          = "NetStream.Play.Start"
+	CodeNetStreamPlayReset          = "NetStream.Play.Reset"
+	CodeNetStreamPlayStop           = "NetStream.Play.Stop"
+	CodeNetStreamPlayFailed         = "NetStream.Play.Failed"
+	CodeNetStreamPlayStreamNotFound = "NetStream.Play.StreamNotFound"
+	CodeNetStreamPlayUnpublish      = "NetStream.Play.UnpublishNotify"
+	CodeNetStreamSeekNotify         = "NetStream.Seek.Notify"
+	CodeNetStreamSeekFailed         = "NetStream.Seek.Failed"
+	CodeNetStreamPauseNotify        = "NetStream.Pause.Notify"
+	CodeNetStreamUnpauseNotify      = "NetStream.Unpause.Notify"
 )
 
+// Command messages are sent on the chunk stream ID 3.
+func newCommandMessage(streamID uint32, payload []byte) *Message {
+	return &Message{
+		ChunkStreamID: 3,
+		TypeID:        MessageCommandAMF0,
+		StreamID:      streamID,
+		Payload:       payload,
+	}
+}
+
+// connectMessage returns the connect command sent by the client.
+func connectMessage(transactionID float64, app, tcURL string) *Message {
+	buf := new(bytes.Buffer)
+	amf.WriteValue(buf, "connect")
+	amf.WriteValue(buf, transactionID)
+	amf.WriteValue(buf, map[string]interface{}{
+		"app":           app,
+		"type":          "nonprivate",
+		"flashVer":      "FMLE/3.0 (compatible; rtmp)",
+		"tcUrl":         tcURL,
+		"fpad":          false,
+		"capabilities":  15,
+		"audioCodecs":   0x0fff,
+		"videoCodecs":   0x00ff,
+		"videoFunction": 1,
+	})
+	return newCommandMessage(0, buf.Bytes())
+}
+
+func GenerateConnect(transactionID float64, app, tcURL string) ([]byte, error) {
+	return encodeMessage(connectMessage(transactionID, app, tcURL))
+}
+
+// callMessage returns the command sent by the client which has no command object,
+// e.g. createStream, publish and play.
+func callMessage(streamID uint32, name string, transactionID float64, args ...interface{}) *Message {
+	buf := new(bytes.Buffer)
+	amf.WriteValue(buf, name)
+	amf.WriteValue(buf, transactionID)
+	amf.WriteValue(buf, nil)
+	for _, arg := range args {
+		amf.WriteValue(buf, arg)
+	}
+	return newCommandMessage(streamID, buf.Bytes())
+}
+
 type CommandLevel string
 
 const (
@@ -44,7 +102,7 @@ func (rc *ResultCommand) Bytes() []byte {
 	return buf.Bytes()
 }
 
-func GenerateConnectResult(transactionID float64) ([]byte, error) {
+func connectResultMessage(transactionID float64) *Message {
 	cmd := &ResultCommand{
 		Name:          "_result",
 		TransactionID: transactionID,
@@ -64,28 +122,38 @@ func GenerateConnectResult(transactionID float64) ([]byte, error) {
 		},
 	}
 	payload := cmd.Bytes()
+	return newCommandMessage(0, payload)
+}
+
+func GenerateConnectResult(transactionID float64) ([]byte, error) {
+	return encodeMessage(connectResultMessage(transactionID))
+}
 
-	ch := &ChunkHeader{
-		BasicHeader: &BasicHeader{
-			FMT:           0,
-			ChunkStreamID: 3,
+// connectErrorMessage returns the _error response for the rejected connect command.
+func connectErrorMessage(transactionID float64, description string) *Message {
+	cmd := &ResultCommand{
+		Name:          "_error",
+		TransactionID: transactionID,
+		Properties: map[string]interface{}{
+			"fmsVer":       "FMS/3,5,7,7009",
+			"capabilities": 31,
+			"mode":         1,
 		},
-		MessageHeader: &MessageHeader{
-			Timestamp:       0,
-			MessageLength:   uint32(len(payload)),
-			MessageTypeID:   20,
-			MessageStreamID: 0,
+		Information: map[string]interface{}{
+			"code":        CodeNetConnectRejected,
+			"description": description,
+			"level":       CommandLevelError,
 		},
 	}
-	header, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
-	}
-	x := append(header, payload...)
-	return x, nil
+	payload := cmd.Bytes()
+	return newCommandMessage(0, payload)
 }
 
-func GenerateOnFCPublishMessage(transactionID float64, streamName string) ([]byte, error) {
+func GenerateConnectError(transactionID float64, description string) ([]byte, error) {
+	return encodeMessage(connectErrorMessage(transactionID, description))
+}
+
+func onFCPublishMessage(transactionID float64, streamName string) *Message {
 	buf := new(bytes.Buffer)
 	amf.WriteValue(buf, "onFCPublish")
 	amf.WriteValue(buf, transactionID)
@@ -97,25 +165,11 @@ func GenerateOnFCPublishMessage(transactionID float64, streamName string) ([]byt
 		"description": fmt.Sprintf("FCPublish to stream %s.", streamName),
 	})
 	payload := buf.Bytes()
+	return newCommandMessage(0, payload)
+}
 
-	ch := &ChunkHeader{
-		BasicHeader: &BasicHeader{
-			FMT:           0,
-			ChunkStreamID: 3,
-		},
-		MessageHeader: &MessageHeader{
-			Timestamp:       0,
-			MessageLength:   uint32(len(payload)),
-			MessageTypeID:   20,
-			MessageStreamID: 0,
-		},
-	}
-	header, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
-	}
-	x := append(header, payload...)
-	return x, nil
+func GenerateOnFCPublishMessage(transactionID float64, streamName string) ([]byte, error) {
+	return encodeMessage(onFCPublishMessage(transactionID, streamName))
 }
 
 type CreateStreamCommand struct {
@@ -134,30 +188,17 @@ func (c *CreateStreamCommand) Bytes() []byte {
 	return buf.Bytes()
 }
 
-func CreateStreamResponseMessage(transactionID float64) ([]byte, error) {
+func createStreamResponseMessage(transactionID float64) *Message {
 	cmd := &CreateStreamCommand{
 		Name:          "_result",
 		TransactionID: transactionID,
 	}
 	payload := cmd.Bytes()
-	ch := &ChunkHeader{
-		BasicHeader: &BasicHeader{
-			FMT:           0,
-			ChunkStreamID: 3,
-		},
-		MessageHeader: &MessageHeader{
-			Timestamp:       0,
-			MessageLength:   uint32(len(payload)),
-			MessageTypeID:   20,
-			MessageStreamID: 0,
-		},
-	}
-	header, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
-	}
-	x := append(header, payload...)
-	return x, nil
+	return newCommandMessage(0, payload)
+}
+
+func CreateStreamResponseMessage(transactionID float64) ([]byte, error) {
+	return encodeMessage(createStreamResponseMessage(transactionID))
 }
 
 type NetStreamStatusMessage struct {
@@ -175,34 +216,28 @@ func (m *NetStreamStatusMessage) Bytes() []byte {
 	return buf.Bytes()
 }
 
-func CreateOnStatusPublishStartMessage(transactionID float64, streamName string) ([]byte, error) {
+// onStatusMessage returns an onStatus command message sent on the message stream.
+func onStatusMessage(streamID uint32, level CommandLevel, code CommandCode, description string) *Message {
 	cmd := &NetStreamStatusMessage{
 		Name:          "onStatus",
-		TransactionID: transactionID,
+		TransactionID: 0,
 		InfoObject: map[string]interface{}{
-			"code":        "NetStream.Publish.Start",
-			"description": fmt.Sprintf("Publishing %s.", streamName),
-			"level":       "status",
+			"level":       level,
+			"code":        code,
+			"description": description,
 		},
 	}
 	payload := cmd.Bytes()
+	return newCommandMessage(streamID, payload)
+}
 
-	ch := &ChunkHeader{
-		BasicHeader: &BasicHeader{
-			FMT:           0,
-			ChunkStreamID: 3,
-		},
-		MessageHeader: &MessageHeader{
-			Timestamp:       0,
-			MessageLength:   uint32(len(payload)),
-			MessageTypeID:   20,
-			MessageStreamID: uint32(math.Pow(2, 4*6)),
-		},
-	}
-	header, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
-	}
-	x := append(header, payload...)
-	return x, nil
+// CreateOnStatusPublishStartMessage returns NetStream.Publish.Start on the message stream 1,
+// which is the ID returned by the createStream response. The transaction ID of onStatus is always 0,
+// and transactionID is ignored.
+func CreateOnStatusPublishStartMessage(transactionID float64, streamName string) ([]byte, error) {
+	return encodeMessage(onStatusMessage(1, CommandLevelStatus, CodeNetStreamPublishStart, publishStartDescription(streamName)))
+}
+
+func publishStartDescription(streamName string) string {
+	return fmt.Sprintf("Publishing %s.", streamName)
 }
@@ -27,7 +27,7 @@ func TestReadConnectMessage(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	ch, err := readChunkHeader(inReader, nil)
+	ch, _, err := readChunkHeader(inReader, newChunkStreamContext())
 	if err != nil {
 		t.Errorf("should be nil, but got %s", err)
 	}
@@ -65,15 +65,16 @@ func TestReadReleaseStreamMessage(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	var chs []*ChunkHeader
+	ctx := newChunkStreamContext()
+	// The connect command message was received on the chunk stream 3 before.
+	ctx.update(&BasicHeader{FMT: 0, ChunkStreamID: 3}, &MessageHeader{MessageTypeID: 20})
 	for {
-		ch, err := readChunkHeader(inReader, chs)
+		ch, _, err := readChunkHeader(inReader, ctx)
 		if err == io.EOF {
 			return
 		} else if err != nil {
 			t.Errorf("should be nil, but got %s", err)
 		}
-		chs = append(chs, ch)
 
 		payload := make([]byte, ch.MessageHeader.MessageLength)
 		_, err = io.ReadAtLeast(inReader, payload, int(ch.MessageHeader.MessageLength))
@@ -109,12 +110,13 @@ func TestReadFCPublishAndCreateStreamMessage(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	var chs []*ChunkHeader
-	ch, err := readChunkHeader(inReader, chs)
+	ctx := newChunkStreamContext()
+	// The connect command message was received on the chunk stream 3 before.
+	ctx.update(&BasicHeader{FMT: 0, ChunkStreamID: 3}, &MessageHeader{MessageTypeID: 20})
+	ch, _, err := readChunkHeader(inReader, ctx)
 	if err != nil {
 		t.Errorf("should be nil, but got %s", err)
 	}
-	chs = append(chs, ch)
 	if ch.BasicHeader.ChunkStreamID != 3 {
 		t.Errorf("ChunkStreamID should be 3, but got %d", ch.BasicHeader.ChunkStreamID)
 	}
@@ -137,11 +139,10 @@ func TestReadFCPublishAndCreateStreamMessage(t *testing.T) {
 		fmt.Printf("Value: %#v\n", v)
 	}
 
-	ch, err = readChunkHeader(inReader, chs)
+	ch, _, err = readChunkHeader(inReader, ctx)
 	if err != nil {
 		t.Errorf("should be nil, but got %s", err)
 	}
-	chs = append(chs, ch)
 	if ch.BasicHeader.ChunkStreamID != 3 {
 		t.Errorf("ChunkStreamID should be 3, but got %d", ch.BasicHeader.ChunkStreamID)
 	}
@@ -180,15 +181,14 @@ func TestParseOnFCPublishAndResultMessage(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	var chs []*ChunkHeader
+	ctx := newChunkStreamContext()
 	for {
-		ch, err := readChunkHeader(inReader, chs)
+		ch, _, err := readChunkHeader(inReader, ctx)
 		if err == io.EOF {
 			return
 		} else if err != nil {
 			t.Errorf("should be nil, but got %s", err)
 		}
-		chs = append(chs, ch)
 
 		payload := make([]byte, ch.MessageHeader.MessageLength)
 		_, err = io.ReadAtLeast(inReader, payload, int(ch.MessageHeader.MessageLength))
@@ -221,15 +221,14 @@ func TestReadUserControlMessageStreamBegin(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	var chs []*ChunkHeader
+	ctx := newChunkStreamContext()
 	for {
-		ch, err := readChunkHeader(inReader, chs)
+		ch, _, err := readChunkHeader(inReader, ctx)
 		if err == io.EOF {
 			return
 		} else if err != nil {
 			t.Errorf("should be nil, but got %s", err)
 		}
-		chs = append(chs, ch)
 
 		payload := make([]byte, ch.MessageHeader.MessageLength)
 		_, err = io.ReadAtLeast(inReader, payload, int(ch.MessageHeader.MessageLength))
@@ -264,15 +263,14 @@ func TestReadPublish(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	var chs []*ChunkHeader
+	ctx := newChunkStreamContext()
 	for {
-		ch, err := readChunkHeader(inReader, chs)
+		ch, _, err := readChunkHeader(inReader, ctx)
 		if err == io.EOF {
 			return
 		} else if err != nil {
 			t.Errorf("should be nil, but got %s", err)
 		}
-		chs = append(chs, ch)
 
 		payload := make([]byte, ch.MessageHeader.MessageLength)
 		_, err = io.ReadAtLeast(inReader, payload, int(ch.MessageHeader.MessageLength))
@@ -312,15 +310,14 @@ func TestReadOnStatusPublish(t *testing.T) {
 	}
 	inReader := bufio.NewReader(bytes.NewBuffer(in))
 
-	var chs []*ChunkHeader
+	ctx := newChunkStreamContext()
 	for {
-		ch, err := readChunkHeader(inReader, chs)
+		ch, _, err := readChunkHeader(inReader, ctx)
 		if err == io.EOF {
 			return
 		} else if err != nil {
 			t.Errorf("should be nil, but got %s", err)
 		}
-		chs = append(chs, ch)
 
 		payload := make([]byte, ch.MessageHeader.MessageLength)
 		_, err = io.ReadAtLeast(inReader, payload, int(ch.MessageHeader.MessageLength))
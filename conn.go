@@ -5,10 +5,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
-	"io/ioutil"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/zhangpeihao/goamf"
 )
@@ -37,6 +39,8 @@ const (
 	StateSentCreateStreamResponse
 	// StatePublishingContent means that server is just receiving content.
 	StatePublishingContent
+	// StatePlayingContent means that server is sending content to the player.
+	StatePlayingContent
 )
 
 type MessageType uint8
@@ -63,31 +67,191 @@ const (
 type conn struct {
 	netconn    net.Conn
 	server     *Server
+	startTime  time.Time
 	bufr       io.Reader
 	bufw       *bufio.Writer
 	state      ConnectionState
+	smu        sync.Mutex // guards state, app, tcURL, streamKey and logger.
 	chunkSize  uint32
 	streamName string
-	oldHeaders []*ChunkHeader
+
+	// handle is passed to the Handler of the server.
+	handle    *Conn
+	app       string // the application name sent by the connect command.
+	tcURL     string // the tcUrl sent by the connect command.
+	streamKey string // the name of the stream published or played.
+	id        uint64
+	logger    *slog.Logger // has the attributes of the connection.
+
+	traceLevel int32 // accessed atomically. See TraceLevel.
+
+	// publishing is the stream published by the peer. nil if the peer is not publishing.
+	publishing *Stream
+	// playing is the subscriber of the stream played by the peer. nil if the peer is not playing.
+	playing *Subscriber
+	// vod is the player of the recorded stream played by the peer. nil if the peer is not playing it.
+	vod *vodPlayer
+
+	// bufferLength is the buffer size (in milliseconds) of the client which is sent by SetBufferLength event.
+	// Accessed atomically.
+	bufferLength uint32
+
+	chunkStreams *chunkStreamContext
+	chunkWriter  *ChunkWriter
+	wmu          sync.Mutex // guards chunkWriter and bufw.
+
+	// Acknowledgement
+	bytesReceived *countingReader
+	bytesSent     *countingWriter
+	ackWindowSize uint32 // the window size sent by the peer. 0 if the peer doesn't want acknowledgements.
+	lastAck       uint32 // the sequence number in the last Acknowledgement message sent to the peer.
+	peerAck       uint32 // the sequence number in the last Acknowledgement message received from the peer.
+
+	// Ping
+	missedPings int32 // the number of PingRequest events which are not responded yet.
+	rtt         int64 // the round-trip time measured by the last PingResponse event in nanoseconds.
+}
+
+// countingReader counts the bytes read from the underlying reader.
+// The count wraps around at 2^32 like the sequence number of Acknowledgement messages.
+type countingReader struct {
+	r io.Reader
+	n uint32
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddUint32(&cr.n, uint32(n))
+	return n, err
+}
+
+func (cr *countingReader) count() uint32 {
+	return atomic.LoadUint32(&cr.n)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n uint32
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddUint32(&cw.n, uint32(n))
+	return n, err
+}
+
+func (cw *countingWriter) count() uint32 {
+	return atomic.LoadUint32(&cw.n)
 }
 
 func (c *conn) serve() error {
+	defer c.netconn.Close()
+	if d := c.server.HandshakeTimeout; d > 0 {
+		c.netconn.SetDeadline(time.Now().Add(d))
+	}
 	if err := c.handshake(); err != nil {
-		c.server.logf("Handshaking Error: %s", err)
-		c.netconn.Close()
+		if isTimeout(err) {
+			c.log().Info("Handshake timeout", "error", err)
+		} else {
+			c.log().Error("Handshake error", "error", err)
+		}
 		return err
 	}
+	c.netconn.SetDeadline(time.Time{})
+	defer func() {
+		if c.publishing != nil {
+			c.publishing.Close()
+		}
+		if c.playing != nil {
+			c.playing.Close()
+		}
+		if c.vod != nil {
+			c.vod.close()
+		}
+		if c.getState() >= StateConnectResponseSent {
+			c.server.handler().OnClose(c.handle)
+		}
+	}()
+
+	if c.server.PingInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go c.keepalive(c.server.PingInterval, c.server.maxMissedPings(), done)
+	}
 
 	for {
 		if err := c.readChunk(); err == io.EOF {
-			c.netconn.Close()
+			c.log().Info("Connection closed by the peer")
 			return nil
+		} else if isTimeout(err) {
+			c.log().Info("Read timeout", "error", err)
+			return err
+		} else if err == errConnectRejected {
+			return err
 		} else if err != nil {
+			c.log().Error("Protocol error", "error", err)
 			return err
 		}
 	}
 }
 
+func (c *conn) getState() ConnectionState {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return c.state
+}
+
+func (c *conn) setState(state ConnectionState) {
+	c.smu.Lock()
+	c.state = state
+	c.smu.Unlock()
+}
+
+func (c *conn) setApp(app, tcURL string) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	c.app = app
+	c.tcURL = tcURL
+	c.logger = c.newLogger()
+}
+
+func (c *conn) setStreamKey(streamKey string) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	c.streamKey = streamKey
+	c.logger = c.newLogger()
+}
+
+// log returns the logger which has the attributes of the connection.
+func (c *conn) log() *slog.Logger {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	return c.logger
+}
+
+// newLogger returns the logger with the remote address, connection ID,
+// app and stream key of the connection. It must be called with smu held.
+func (c *conn) newLogger() *slog.Logger {
+	args := []interface{}{
+		"remote_addr", c.netconn.RemoteAddr().String(),
+		"conn_id", c.id,
+	}
+	if c.app != "" {
+		args = append(args, "app", c.app)
+	}
+	if c.streamKey != "" {
+		args = append(args, "stream_key", c.streamKey)
+	}
+	return c.server.logger().With(args...)
+}
+
+// isTimeout reports whether err is caused by the deadline of the connection.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
 //
 // +-------------+                            +-------------+
 // |    Client   |       TCP/IP Network       |    Server   |
@@ -121,112 +285,167 @@ func (c *conn) serve() error {
 //
 
 func (c *conn) handshake() error {
-	c.server.logf("Begin RTMP Handshake.")
+	c.trace(TraceChunks, "Begin RTMP handshake")
 
 	// << C0
 	c0, err := readC0S0(c.bufr)
 	if err != nil {
-		c.server.logf("Read C0 error: %s", err)
+		c.trace(TraceChunks, "Read C0 error", "error", err)
 		return err
 	} else if c0.version > 3 {
 		err = errors.New("unsupported rtmp version")
-		c.server.logf("%s: %#v", err, c0.version)
+		c.trace(TraceChunks, "Read C0 error", "error", err, "version", c0.version)
 		return err
 	}
-	c.server.logf("Receive a C0 chunk.")
+	c.trace(TraceChunks, "Receive a C0 chunk")
 	// >> S0
 	s0 := newChunkC0S0()
 	if _, err := c.bufw.Write(s0.Bytes()); err != nil {
-		c.server.logf("Write S0 error: %s", err)
+		c.trace(TraceChunks, "Write S0 error", "error", err)
 		return err
 	}
 	if err := c.bufw.Flush(); err != nil {
-		c.server.logf("Flush S0 error: %s", err)
+		c.trace(TraceChunks, "Flush S0 error", "error", err)
 		return err
 	}
-	c.server.logf("Send a S0 chunk.")
-	c.state = StateVersionSent
+	c.trace(TraceChunks, "Send a S0 chunk")
+	c.setState(StateVersionSent)
 
 	// << C1
 	c1, err := readC1S1(c.bufr)
 	if err != nil {
-		c.server.logf("Read C1 error: %s", err)
+		c.trace(TraceChunks, "Read C1 error", "error", err)
 		return err
 	}
-	c.server.logf("Receive a C1 chunk.")
+	c.trace(TraceChunks, "Receive a C1 chunk")
 	// >> S1
 	s1 := newChunkC1S1(0)
 	if _, err := c.bufw.Write(s1.Bytes()); err != nil {
-		c.server.logf("Write S1 error: %s", err)
+		c.trace(TraceChunks, "Write S1 error", "error", err)
 		return err
 	}
 	if err := c.bufw.Flush(); err != nil {
-		c.server.logf("Flush S1 error: %s", err)
+		c.trace(TraceChunks, "Flush S1 error", "error", err)
 		return err
 	}
-	c.server.logf("Send a S1 chunk.")
-	c.state = StateAckSent
+	c.trace(TraceChunks, "Send a S1 chunk")
+	c.setState(StateAckSent)
 
 	// >> S2
 	s2 := newChunkC2S2(c1)
 	if _, err = c.bufw.Write(s2.Bytes()); err != nil {
-		c.server.logf("Write S2 error: %s", err)
+		c.trace(TraceChunks, "Write S2 error", "error", err)
 		return err
 	}
 	if err := c.bufw.Flush(); err != nil {
-		c.server.logf("Flush S2 error: %s", err)
+		c.trace(TraceChunks, "Flush S2 error", "error", err)
 		return err
 	}
-	c.server.logf("Send a S2 chunk.")
+	c.trace(TraceChunks, "Send a S2 chunk")
 
 	// << C2
 	c2, err := readC2S2(c.bufr)
 	if err != nil {
-		c.server.logf("Read C2 error: %s", err)
+		c.trace(TraceChunks, "Read C2 error", "error", err)
 		return err
 	}
-	c.server.logf("Receive a C2 chunk.")
+	c.trace(TraceChunks, "Receive a C2 chunk")
 	if bytes.Compare(c2.randomEcho, s1.randomBytes) != 0 {
 		return errors.New("random echo doesn't match")
 	}
-	c.state = StateHandshakeDone
+	c.setState(StateHandshakeDone)
 	return nil
 }
 
-var bindex = 0
+// errConnectRejected is returned when the Handler rejects the connect command.
+var errConnectRejected = errors.New("connect command is rejected")
 
 func (c *conn) readChunk() error {
-	header, _, err := readChunkHeader(c.bufr, c.oldHeaders)
-	if err == io.EOF {
-		c.server.logf("Got EOF")
-		return nil
-	} else if err != nil {
-		c.server.logf("Error while readChunkHeader: %s", err)
+	if c.getState() == StatePlayingContent {
+		// A player sends nothing but occasional acknowledgements while playing.
+		// The dead players are detected by the missed pings or the write timeout instead.
+		c.netconn.SetReadDeadline(time.Time{})
+	} else if d := c.server.idleTimeout(); d > 0 {
+		c.netconn.SetReadDeadline(time.Now().Add(d))
+	}
+	header, _, err := readChunkHeader(c.bufr, c.chunkStreams)
+	if err != nil {
 		return err
 	}
+	if c.tracing(TraceChunks) {
+		c.log().Debug("Receive a chunk header",
+			"fmt", header.BasicHeader.FMT,
+			"csid", header.BasicHeader.ChunkStreamID,
+			"timestamp", header.MessageHeader.Timestamp,
+			"delta", header.MessageHeader.TimestampDelta,
+			"length", header.MessageHeader.MessageLength,
+			"type", header.MessageHeader.MessageTypeID,
+			"stream_id", header.MessageHeader.MessageStreamID,
+		)
+	}
 
-	if len(c.oldHeaders) > 100 {
-		c.oldHeaders = c.oldHeaders[:len(c.oldHeaders)-1]
+	if d := c.server.ReadTimeout; d > 0 {
+		c.netconn.SetReadDeadline(time.Now().Add(d))
 	}
-	c.oldHeaders = append([]*ChunkHeader{header}, c.oldHeaders...)
+	cs := c.chunkStreams.get(header.BasicHeader.ChunkStreamID)
+	msg, err := cs.readChunkPayload(c.bufr, header, c.chunkSize)
+	if err != nil {
+		return err
+	}
+	if err = c.acknowledge(); err != nil {
+		return err
+	}
+	if msg == nil {
+		// Wait for the rest of chunks.
+		return nil
+	}
+	return c.handleMessage(msg)
+}
 
-	switch MessageType(header.MessageHeader.MessageTypeID) {
+// acknowledge sends an Acknowledgement message to the peer
+// if the bytes received since the last acknowledgement reach the window size.
+func (c *conn) acknowledge() error {
+	if c.ackWindowSize == 0 {
+		return nil
+	}
+	received := c.bytesReceived.count()
+	if received-c.lastAck < c.ackWindowSize {
+		return nil
+	}
+	c.lastAck = received
+	return c.writeMessages(acknowledgementMessage(received))
+}
+
+// notifyShutdown sends NetConnection.Connect.AppShutdown to the peer
+// if the connection has been established.
+func (c *conn) notifyShutdown() error {
+	if c.getState() < StateConnectResponseSent {
+		return nil
+	}
+	return c.writeMessages(onStatusMessage(0, CommandLevelError, CodeNetConnectAppShutdown, "The server is shutting down."))
+}
+
+// abort sends an Abort message to notify the peer that it should discard
+// the partially received message on the chunk stream.
+func (c *conn) abort(csid uint32) error {
+	return c.writeMessages(abortMessage(csid))
+}
+
+// unacknowledgedBytes returns the bytes sent to the peer but not acknowledged yet.
+// It shows how far behind the peer is if the peer sends Acknowledgement messages.
+func (c *conn) unacknowledgedBytes() uint32 {
+	return c.bytesSent.count() - atomic.LoadUint32(&c.peerAck)
+}
+
+func (c *conn) handleMessage(msg *Message) error {
+	switch msg.TypeID {
 	case MessageSetChunkSize:
-		//  0                   1                   2                   3
-		//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
-		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		// |0|                   chunk size (31 bits)                      |
-		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		if header.MessageHeader.MessageLength != 4 {
-			return errors.New("the payload length of Set Chunk Size command should be 4")
-		}
-		payload := make([]byte, 4)
-		_, err := io.ReadFull(c.bufr, payload)
+		chunkSize, err := readChunkSize(msg.Payload)
 		if err != nil {
 			return err
 		}
-		c.chunkSize = binary.BigEndian.Uint32(payload)
-		c.server.logf("Set Chunk Size: %d", c.chunkSize)
+		c.chunkSize = chunkSize
+		c.trace(TraceMessages, "Set Chunk Size", "chunk_size", c.chunkSize)
 		return nil
 	case MessageAbort:
 		//  0                   1                   2                   3
@@ -234,13 +453,12 @@ func (c *conn) readChunk() error {
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 		// |                   chunk stream id (32 bits)                   |
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
+		if len(msg.Payload) < 4 {
+			return errors.New("the payload length of Abort message should be 4")
 		}
-		csid := binary.BigEndian.Uint32(payload)
-		c.server.logf("Abort Message: %d", csid)
+		csid := binary.BigEndian.Uint32(msg.Payload)
+		c.chunkStreams.abort(csid)
+		c.trace(TraceMessages, "Abort", "csid", csid)
 		return nil
 	case MessageAcknowledgement:
 		//  0                   1                   2                   3
@@ -248,35 +466,31 @@ func (c *conn) readChunk() error {
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 		// |                    sequence number (4 bytes)                  |
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
+		if len(msg.Payload) < 4 {
+			return errors.New("the payload length of Acknowledgement message should be 4")
 		}
-		sequenceNumber := binary.BigEndian.Uint32(payload)
-		c.server.logf("Acknowledgement Message: %d", sequenceNumber)
+		sequenceNumber := binary.BigEndian.Uint32(msg.Payload)
+		atomic.StoreUint32(&c.peerAck, sequenceNumber)
+		c.trace(TraceMessages, "Acknowledgement", "sequence_number", sequenceNumber, "unacknowledged", c.unacknowledgedBytes())
 		return nil
 	case MessageUserControl:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
+		e, err := ParseUserControlEvent(msg.Payload)
 		if err != nil {
-			return err
+			c.log().Warn("Invalid User Control Message", "error", err, "payload", msg.Payload)
+			return nil
 		}
-		c.server.logf("User Control Message\n")
-		c.server.logf("  payload : %#v\n", payload)
+		return c.handleUserControlEvent(e)
 	case MessageAcknowledgementWindowSize:
 		//  0                   1                   2                   3
 		//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 		// |              Acknowledgement Window size (4 bytes)            |
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
+		if len(msg.Payload) < 4 {
+			return errors.New("the payload length of Window Acknowledgement Size message should be 4")
 		}
-		ackWindowSize := binary.BigEndian.Uint32(payload)
-		c.server.logf("WindowAcknowledgementSize Message: %d", ackWindowSize)
+		c.ackWindowSize = binary.BigEndian.Uint32(msg.Payload)
+		c.trace(TraceMessages, "Window Acknowledgement Size", "size", c.ackWindowSize)
 		return nil
 	case MessageSetPeerBandwidth:
 		//  0                   1                   2                   3
@@ -286,128 +500,141 @@ func (c *conn) readChunk() error {
 		// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 		// |  Limit Type   |
 		// +-+-+-+-+-+-+-+-+
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
+		if len(msg.Payload) < 5 {
+			return errors.New("the payload length of Set Peer Bandwidth message should be 5")
 		}
-		ackWindowSize := binary.BigEndian.Uint32(payload[:4])
-		limitType := payload[4]
-		c.server.logf("SetPeerBandWidth Message: %d, %d", ackWindowSize, limitType)
+		ackWindowSize := binary.BigEndian.Uint32(msg.Payload[:4])
+		limitType := msg.Payload[4]
+		c.trace(TraceMessages, "Set Peer Bandwidth", "size", ackWindowSize, "limit_type", limitType)
 		return nil
 	case MessageAudio:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
+		c.traceMessage("Receive an audio message", msg)
+		if err := c.server.handler().OnAudio(c.handle, msg); err != nil {
 			return err
 		}
-		ioutil.WriteFile(fmt.Sprintf("%05d.bin", bindex), payload, 0644)
-		bindex += 1
-		c.server.logf("Catch audio message fmt=%v cstreamid=%v ts=%v:%v mlen=%v mtyp=%v mstre=%v e=%v",
-			header.BasicHeader.FMT,
-			header.BasicHeader.ChunkStreamID,
-			header.MessageHeader.Timestamp,
-			header.MessageHeader.TimestampDelta,
-			header.MessageHeader.MessageLength,
-			header.MessageHeader.MessageTypeID,
-			header.MessageHeader.MessageStreamID,
-			header.ExtendedTimestamp,
-		)
+		c.publish(msg)
 	case MessageVideo:
-		r := header.MessageHeader.MessageLength
-		if r > c.chunkSize {
-			r = c.chunkSize
-			header.MessageHeader.MessageLength -= r
-		}
-		payload := make([]byte, r)
-		l, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
+		c.traceMessage("Receive a video message", msg)
+		if err := c.server.handler().OnVideo(c.handle, msg); err != nil {
 			return err
 		}
-		c.server.logf("Catch video message fmt=%v cstreamid=%v ts=%v:%v mlen=%v mtyp=%v mstre=%v e=%v l=%v",
-			header.BasicHeader.FMT,
-			header.BasicHeader.ChunkStreamID,
-			header.MessageHeader.Timestamp,
-			header.MessageHeader.TimestampDelta,
-			header.MessageHeader.MessageLength,
-			header.MessageHeader.MessageTypeID,
-			header.MessageHeader.MessageStreamID,
-			header.ExtendedTimestamp,
-			l,
-		)
-		ioutil.WriteFile(fmt.Sprintf("%05d.bin", bindex), payload, 0644)
-		bindex += 1
-		//c.server.logf("data=%v", payload[header.MessageHeader.MessageLength-1])
+		c.publish(msg)
 	case MessageDataAMF3:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
-		}
-		c.server.logf("Catch DataMessage(AMF3)")
+		c.traceMessage("Receive a data message (AMF3)", msg)
 	case MessageCommandAMF3:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
-		}
-		c.server.logf("Catch AMF3 Command Message")
+		c.traceMessage("Receive a command message (AMF3)", msg)
 	case MessageSharedObjectAMF3:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
-		}
-		c.server.logf("Catch SharedObjectMessage(AMF0)")
+		c.traceMessage("Receive a shared object message (AMF3)", msg)
 	case MessageDataAMF0:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
+		c.traceMessage("Receive a data message (AMF0)", msg)
+		if err := c.server.handler().OnData(c.handle, msg); err != nil {
 			return err
 		}
-		c.server.logf("Catch DataMessage(AMF0)")
+		c.publish(msg)
 	case MessageCommandAMF0:
-		c.server.logf("Catch AMF0 Command Message")
-		err = c.handleCommandMessageAMF0(header)
+		c.traceMessage("Receive a command message (AMF0)", msg)
+		err := c.handleCommandMessageAMF0(msg)
 		if err != nil {
 			return err
 		}
 	case MessageSharedObjectAMF0:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
-		}
-		c.server.logf("Catch SharedObjectMessage(AMF0)")
+		c.traceMessage("Receive a shared object message (AMF0)", msg)
 	case MessageAggregate:
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
-		}
-		c.server.logf("Catch AggregateMessage")
+		c.traceMessage("Receive an aggregate message", msg)
 	default:
-		c.server.logf("Catch unknown message type id: %d", header.MessageHeader.MessageTypeID)
-		c.server.logf("%#v", header.BasicHeader)
-		c.server.logf("%#v", header.MessageHeader)
-		payload := make([]byte, header.MessageHeader.MessageLength)
-		_, err := io.ReadFull(c.bufr, payload)
-		if err != nil {
-			return err
-		}
+		c.traceMessage("Receive an unknown message", msg)
 		return nil
 	}
 	return nil
 }
 
-func (c *conn) handleCommandMessageAMF0(header *ChunkHeader) error {
-	ml := header.MessageHeader.MessageLength
-	payload := make([]byte, ml)
-	_, err := io.ReadFull(c.bufr, payload)
+// record starts recording the published stream if Server.Record is set.
+// The recording finishes when the stream is closed.
+func (c *conn) record(stream *Stream) {
+	if c.server.Record == nil {
+		return
+	}
+	w, err := c.server.Record(stream.App(), stream.Name())
 	if err != nil {
-		return err
+		c.log().Error("Open recording error", "error", err)
+		return
+	} else if w == nil {
+		return
 	}
+	r, err := NewRecorder(stream, w)
+	if err != nil {
+		w.Close()
+		c.log().Error("Start recording error", "error", err)
+		return
+	}
+	c.log().Info("Start recording")
+	go func() {
+		if err := r.Wait(); err != nil {
+			c.log().Error("Recording error", "error", err)
+			return
+		}
+		c.log().Info("Finish recording")
+	}()
+}
+
+// relay starts pushing the published stream to the upstream servers if Server.Relay is set.
+// The relays stop when the stream is closed.
+func (c *conn) relay(stream *Stream) {
+	if c.server.Relay == nil {
+		return
+	}
+	for _, u := range c.server.Relay(stream.App(), stream.Name()) {
+		startRelay(stream, u, c.log().With("upstream", redactStreamURL(u)))
+	}
+}
 
+// publish sends the media or data message to the subscribers of the stream
+// if the connection is publishing.
+func (c *conn) publish(msg *Message) {
+	if c.publishing == nil {
+		return
+	}
+	switch c.publishing.writeMessage(msg) {
+	case streamUpdateMetadata:
+		metadata := c.publishing.Metadata()
+		if metadata == nil {
+			c.log().Warn("Invalid metadata")
+			return
+		}
+		c.log().Info("Receive metadata",
+			"width", metadata.Width,
+			"height", metadata.Height,
+			"framerate", metadata.FrameRate,
+			"encoder", metadata.Encoder,
+		)
+		c.server.handler().OnMetadata(c.handle, c.publishing, metadata)
+	case streamUpdateSequenceHeader:
+		c.server.handler().OnSequenceHeader(c.handle, c.publishing, false)
+	case streamUpdateSequenceHeaderChanged:
+		c.log().Info("Sequence header is changed", "type", msg.TypeID)
+		c.server.handler().OnSequenceHeader(c.handle, c.publishing, true)
+	}
+}
+
+func (c *conn) handleUserControlEvent(e *UserControlEvent) error {
+	switch e.Type {
+	case UserControlSetBufferLength:
+		atomic.StoreUint32(&c.bufferLength, e.BufferLength)
+		c.trace(TraceMessages, "User Control SetBufferLength", "stream_id", e.StreamID, "buffer_length", e.BufferLength)
+	case UserControlPingRequest:
+		c.trace(TraceMessages, "User Control PingRequest", "timestamp", e.Timestamp)
+		return c.writeMessages(userPingResponseMessage(e.Timestamp))
+	case UserControlPingResponse:
+		c.pong(e.Timestamp)
+		c.trace(TraceMessages, "User Control PingResponse", "timestamp", e.Timestamp, "rtt", c.roundTripTime())
+	default:
+		c.trace(TraceMessages, "User Control", "type", e.Type, "stream_id", e.StreamID)
+	}
+	return nil
+}
+
+func (c *conn) handleCommandMessageAMF0(msg *Message) error {
+	payload := msg.Payload
 	buf := bytes.NewBuffer(payload)
 	commandName, err := amf.ReadString(buf)
 	if err != nil {
@@ -420,64 +647,42 @@ func (c *conn) handleCommandMessageAMF0(header *ChunkHeader) error {
 
 	switch commandName {
 	case "connect":
-		c.server.logf("Receive connect command message (transactionID: %f).", transactionID)
-		// Send window acknowledgement
-		was, err := GenerateWindowAcknowledgementSizeChunk(WindowAcknowledgementSize)
+		c.trace(TraceMessages, "Receive a connect command", "transaction_id", transactionID)
+		params, err := readCommandObject(buf)
 		if err != nil {
 			return err
 		}
-		_, err = c.bufw.Write(was)
-		if err != nil {
-			return err
+		app, _ := params["app"].(string)
+		tcURL, _ := params["tcUrl"].(string)
+		c.setApp(app, tcURL)
+		if err = c.server.handler().OnConnect(c.handle, app, tcURL, params); err != nil {
+			c.log().Info("Reject connect", "error", err)
+			if werr := c.writeMessages(connectErrorMessage(transactionID, err.Error())); werr != nil {
+				return werr
+			}
+			return errConnectRejected
 		}
-
-		// Send peer bandwidth
-		pbw, err := GenerateSetPeerBandwidthChunk(PeerBandWidth, PeerBandwidthLimitTypeDynamic)
-		if err != nil {
-			return err
-		}
-		_, err = c.bufw.Write(pbw)
-		if err != nil {
-			return err
+		msgs := []*Message{
+			// Send window acknowledgement
+			windowAcknowledgementSizeMessage(WindowAcknowledgementSize),
+			// Send peer bandwidth
+			setPeerBandwidthMessage(PeerBandWidth, PeerBandwidthLimitTypeDynamic),
+			// Send User Control Message Events - StreamBegin
+			userStreamBeginMessage(0),
+			// Set Chunk Size (size = 4096)
+			setChunkSizeMessage(4096),
+			// Command Message: _result (connect)
+			connectResultMessage(transactionID),
 		}
-
-		// Send User Control Message Events - StreamBegin
-		usb, err := GenerateUserStreamBegin(0)
+		err = c.writeMessages(msgs...)
 		if err != nil {
 			return err
 		}
-		_, err = c.bufw.Write(usb)
-		if err != nil {
-			return err
-		}
-
-		// Set Chunk Size (size = 4096)
-		scs, err := GenerateSetChunkSize(4096)
-		if err != nil {
-			return err
-		}
-		_, err = c.bufw.Write(scs)
-		if err != nil {
-			return err
-		}
-
-		// Command Message: _result (connect)
-		x, err := GenerateConnectResult(transactionID)
-		if err != nil {
-			return err
-		}
-		_, err = c.bufw.Write(x)
-		if err != nil {
-			return err
-		}
-		err = c.bufw.Flush()
-		if err != nil {
-			return err
-		}
-		c.state = StateConnectResponseSent
+		c.setState(StateConnectResponseSent)
+		c.log().Info("Connected", "tc_url", tcURL)
 	case "releaseStream":
-		c.server.logf("Receive a releaseStream command (transactionID: %f).", transactionID)
-		if c.state < StateConnectResponseSent {
+		c.trace(TraceMessages, "Receive a releaseStream command", "transaction_id", transactionID)
+		if c.getState() < StateConnectResponseSent {
 			return errors.New("connect response should be sent before receiving a releaseStream command")
 		}
 		//_, err := amf.ReadValue(buf) // Returns null-type
@@ -497,70 +702,148 @@ func (c *conn) handleCommandMessageAMF0(header *ChunkHeader) error {
 		if err != nil {
 			return err
 		}
-		c.server.logf("Receive FCPublish command message (transactionID: %f, streamName: %s).", transactionID, streamName)
+		c.trace(TraceMessages, "Receive a FCPublish command", "transaction_id", transactionID, "stream_name", streamName)
 
-		msg, err := GenerateOnFCPublishMessage(transactionID, streamName)
+		err = c.writeMessages(onFCPublishMessage(transactionID, streamName))
 		if err != nil {
 			return err
 		}
-		_, err = c.bufw.Write(msg)
+		c.streamName = streamName
+		return nil
+	case "createStream":
+		c.trace(TraceMessages, "Receive a createStream command", "transaction_id", transactionID)
+		err = c.writeMessages(createStreamResponseMessage(transactionID))
 		if err != nil {
 			return err
 		}
-		err = c.bufw.Flush()
+		c.setState(StateSentCreateStreamResponse)
+		return nil
+	case "publish":
+		c.trace(TraceMessages, "Receive a publish command", "transaction_id", transactionID)
+		if c.getState() < StateSentCreateStreamResponse {
+			return errors.New("connect response should be sent before receiving a releaseStream command")
+		} else if c.getState() == StatePublishingContent {
+			c.log().Warn("Receive a publish command while publishing")
+			return nil
+		}
+		_, err := amf.ReadValue(buf) // Returns null-type
 		if err != nil {
 			return err
 		}
-		c.streamName = streamName
-		return nil
-	case "createStream":
-		c.server.logf("Catch createStream command message - (transactionID: %f)", transactionID)
-		msg, err := CreateStreamResponseMessage(transactionID)
+		streamKey, err := amf.ReadString(buf) // Should return publishingName(string)
 		if err != nil {
 			return err
 		}
-		_, err = c.bufw.Write(msg)
+		c.setStreamKey(streamKey)
+		if err = c.server.handler().OnPublish(c.handle, streamKey); err != nil {
+			c.log().Info("Reject publish", "error", err)
+			return c.writeMessages(onStatusMessage(msg.StreamID, CommandLevelError, CodeNetStreamPublishBadName, err.Error()))
+		}
+		stream, err := c.server.streamHub().Publish(c.handle.App(), streamKey)
 		if err != nil {
-			return err
+			c.log().Info("Reject publish", "error", err)
+			return c.writeMessages(onStatusMessage(msg.StreamID, CommandLevelError, CodeNetStreamPublishBadName, err.Error()))
 		}
-		err = c.bufw.Flush()
+		// returns user control message(stream begin)
+		err = c.writeMessages(
+			userStreamBeginMessage(msg.StreamID),
+			onStatusMessage(msg.StreamID, CommandLevelStatus, CodeNetStreamPublishStart, publishStartDescription(streamKey)),
+		)
 		if err != nil {
+			stream.Close()
 			return err
 		}
-		c.state = StateSentCreateStreamResponse
-		return nil
-	case "publish":
-		c.server.logf("Catch publish command message - (transactionID: %f)", transactionID)
-		if c.state < StateSentCreateStreamResponse {
-			return errors.New("connect response should be sent before receiving a releaseStream command")
-		} else if c.state == StatePublishingContent {
-			c.server.logf("Catch publish command message in StateSentCreateStreamResponse")
+		c.publishing = stream
+		c.record(stream)
+		c.relay(stream)
+		c.setState(StatePublishingContent)
+		c.log().Info("Start publishing")
+	case "play":
+		c.trace(TraceMessages, "Receive a play command", "transaction_id", transactionID)
+		if c.getState() < StateSentCreateStreamResponse {
+			return errors.New("createStream response should be sent before receiving a play command")
+		}
+		if c.getState() == StatePlayingContent {
+			c.log().Warn("Receive a play command while playing")
 			return nil
 		}
-		// returns user control message(stream begin)
-		var msg []byte
-		msg, err = GenerateUserStreamBegin(1)
+		args, err := readPlayArguments(buf)
 		if err != nil {
 			return err
 		}
-		_, err = c.bufw.Write(msg)
+		return c.play(msg.StreamID, args)
+	case "seek":
+		c.trace(TraceMessages, "Receive a seek command", "transaction_id", transactionID)
+		_, err := amf.ReadValue(buf) // Returns null-type
 		if err != nil {
 			return err
 		}
-		msg, err = CreateOnStatusPublishStartMessage(transactionID, c.streamName)
+		ms, err := amf.ReadDouble(buf)
 		if err != nil {
 			return err
 		}
-		_, err = c.bufw.Write(msg)
+		if ms < 0 {
+			ms = 0
+		}
+		if c.vod == nil || !c.vod.seek(uint32(ms)) {
+			c.log().Info("Receive a seek command while not playing a recorded stream")
+			return c.writeMessages(onStatusMessage(msg.StreamID, CommandLevelError, CodeNetStreamSeekFailed,
+				"Seeking is not supported."))
+		}
+	case "pause":
+		c.trace(TraceMessages, "Receive a pause command", "transaction_id", transactionID)
+		_, err := amf.ReadValue(buf) // Returns null-type
 		if err != nil {
 			return err
 		}
-
-		err = c.bufw.Flush()
+		pause, err := amf.ReadBoolean(buf)
+		if err != nil {
+			return err
+		}
+		// The position in milliseconds where the stream is paused, or to resume from.
+		ms, err := amf.ReadDouble(buf)
 		if err != nil {
 			return err
 		}
-		c.state = StatePublishingContent
+		if ms < 0 {
+			ms = 0
+		}
+		if c.vod == nil || !c.vod.pause(pause, uint32(ms)) {
+			c.log().Warn("Receive a pause command while not playing a recorded stream")
+		}
 	}
 	return nil
 }
+
+// readCommandObject reads the command object of the command message.
+// It returns an empty map if the command object is null.
+func readCommandObject(r amf.Reader) (map[string]interface{}, error) {
+	v, err := amf.ReadValue(r)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := v.(type) {
+	case amf.Object:
+		return obj, nil
+	case map[string]interface{}:
+		return obj, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+// writeMessages writes the messages through the chunk writer and flushes them.
+// It is safe to call from multiple goroutines.
+func (c *conn) writeMessages(msgs ...*Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if d := c.server.WriteTimeout; d > 0 {
+		c.netconn.SetWriteDeadline(time.Now().Add(d))
+	}
+	for _, msg := range msgs {
+		c.traceMessage("Send a message", msg)
+		if err := c.chunkWriter.WriteMessage(msg); err != nil {
+			return err
+		}
+	}
+	return c.bufw.Flush()
+}
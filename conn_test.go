@@ -0,0 +1,255 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnSendsAcknowledgement(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := new(Server).newConn(server)
+	defer server.Close()
+
+	in := new(bytes.Buffer)
+	// Window Acknowledgement Size (size = 100)
+	x, _ := GenerateWindowAcknowledgementSizeChunk(100)
+	in.Write(x)
+	// Data message (120 bytes)
+	x, _ = encodeMessage(&Message{ChunkStreamID: 4, TypeID: MessageDataAMF0, StreamID: 1, Payload: make([]byte, 120)})
+	in.Write(x)
+	received := uint32(in.Len())
+
+	go client.Write(in.Bytes())
+	errc := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2; i++ {
+			if err := c.readChunk(); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+
+	expected, _ := GenerateAcknowledgementChunk(received)
+	actual := make([]byte, len(expected))
+	if _, err := io.ReadFull(client, actual); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if bytes.Compare(expected, actual) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+}
+
+func TestConnUnacknowledgedBytes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := new(Server).newConn(server)
+	defer server.Close()
+	go io.Copy(ioutil.Discard, client)
+
+	msg := &Message{ChunkStreamID: 6, TypeID: MessageVideo, StreamID: 1, Payload: make([]byte, 300)}
+	x, _ := encodeMessage(msg)
+	if err := c.writeMessages(msg); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if actual := c.handle.UnacknowledgedBytes(); actual != uint32(len(x)) {
+		t.Errorf("Should be %#v, but got %#v", len(x), actual)
+	}
+	if err := c.handleMessage(acknowledgementMessage(100)); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if actual := c.handle.UnacknowledgedBytes(); actual != uint32(len(x)-100) {
+		t.Errorf("Should be %#v, but got %#v", len(x)-100, actual)
+	}
+}
+
+func TestConnBufferLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := new(Server).newConn(server)
+	defer server.Close()
+
+	if actual := c.handle.BufferLength(); actual != 0 {
+		t.Errorf("Should be 0, but got %s", actual)
+	}
+	if err := c.handleUserControlEvent(&UserControlEvent{Type: UserControlSetBufferLength, StreamID: 1, BufferLength: 3000}); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if actual := c.handle.BufferLength(); actual != 3*time.Second {
+		t.Errorf("Should be %s, but got %s", 3*time.Second, actual)
+	}
+}
+
+func TestConnAbort(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := new(Server).newConn(server)
+	defer server.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.handle.Abort(4)
+	}()
+	expected, _ := GenerateAbortChunk(4)
+	actual := make([]byte, len(expected))
+	if _, err := io.ReadFull(client, actual); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if bytes.Compare(expected, actual) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+}
+
+func TestConnKeepalive(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := new(Server).newConn(server)
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.keepalive(10*time.Millisecond, 2, done)
+
+	br := bufio.NewReader(client)
+	ctx := newChunkStreamContext()
+	readEvent := func() (*UserControlEvent, error) {
+		ch, _, err := readChunkHeader(br, ctx)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := ctx.get(ch.BasicHeader.ChunkStreamID).readChunkPayload(br, ch, DefaultChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		return ParseUserControlEvent(msg.Payload)
+	}
+
+	e, err := readEvent()
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if e.Type != UserControlPingRequest {
+		t.Fatalf("Should be PingRequest, but got %#v", e)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err = c.handleUserControlEvent(&UserControlEvent{Type: UserControlPingResponse, Timestamp: e.Timestamp}); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if c.roundTripTime() < 5*time.Millisecond {
+		t.Errorf("Should be greater than 5ms, but got %s", c.roundTripTime())
+	}
+
+	// The connection is closed after 2 PingRequest events are missed.
+	for i := 0; i < 2; i++ {
+		if _, err = readEvent(); err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+	}
+	if _, err = readEvent(); err != io.EOF {
+		t.Errorf("Should be EOF, but got %v", err)
+	}
+}
+
+func TestConnHandshakeTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := (&Server{HandshakeTimeout: 10 * time.Millisecond}).newConn(server)
+
+	// The client sends nothing after C0.
+	go client.Write([]byte{0x03})
+	go io.Copy(ioutil.Discard, client)
+	err := c.serve()
+	if !isTimeout(err) {
+		t.Errorf("Should be a timeout error, but got %v", err)
+	}
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := (&Server{IdleTimeout: 10 * time.Millisecond}).newConn(server)
+
+	err := c.readChunk()
+	if !isTimeout(err) {
+		t.Errorf("Should be a timeout error, but got %v", err)
+	}
+}
+
+func TestConnIdleTimeoutWhilePlaying(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := (&Server{ReadTimeout: 10 * time.Millisecond}).newConn(server)
+	c.setState(StatePlayingContent)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.readChunk()
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("Should wait for the silent player, but got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	x, _ := GenerateAcknowledgementChunk(100)
+	client.Write(x)
+	if err := <-errc; err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+}
+
+func TestConnTraceLevel(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	out := new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := (&Server{Logger: logger}).newConn(server)
+
+	x, _ := GenerateWindowAcknowledgementSizeChunk(100)
+	go client.Write(append(x, x...))
+
+	if err := c.readChunk(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Should be empty, but got %#v", out.String())
+	}
+
+	c.handle.SetTraceLevel(TraceChunks)
+	if err := c.readChunk(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	for _, expected := range []string{`msg="Receive a chunk header"`, `msg="Window Acknowledgement Size" remote_addr=pipe conn_id=1 size=100`} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Should contain %#v, but got %#v", expected, out.String())
+		}
+	}
+}
+
+func TestConnLoggerAttributes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	out := new(bytes.Buffer)
+	c := (&Server{Logger: slog.New(slog.NewTextHandler(out, nil))}).newConn(server)
+
+	c.setApp("live", "rtmp://localhost/live")
+	c.setStreamKey("key")
+	c.log().Info("test")
+	expected := "remote_addr=pipe conn_id=1 app=live stream_key=key"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("Should contain %#v, but got %#v", expected, out.String())
+	}
+}
@@ -0,0 +1,40 @@
+package rtmp
+
+import (
+	"bytes"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// Data messages are sent on the chunk stream ID 5.
+func newDataMessage(streamID uint32, payload []byte) *Message {
+	return &Message{
+		ChunkStreamID: 5,
+		TypeID:        MessageDataAMF0,
+		StreamID:      streamID,
+		Payload:       payload,
+	}
+}
+
+// rtmpSampleAccessMessage allows the player to access the raw audio and video data.
+func rtmpSampleAccessMessage(streamID uint32) *Message {
+	buf := new(bytes.Buffer)
+	amf.WriteValue(buf, "|RtmpSampleAccess")
+	amf.WriteValue(buf, true)
+	amf.WriteValue(buf, true)
+	return newDataMessage(streamID, buf.Bytes())
+}
+
+func GenerateRtmpSampleAccess(streamID uint32) ([]byte, error) {
+	return encodeMessage(rtmpSampleAccessMessage(streamID))
+}
+
+// isMetadata reports whether the payload of the data message is onMetaData
+// or @setDataFrame which sets onMetaData.
+func isMetadata(payload []byte) bool {
+	name, err := amf.ReadString(bytes.NewBuffer(bytes.TrimPrefix(payload, setDataFrame)))
+	if err != nil {
+		return false
+	}
+	return name == "onMetaData"
+}
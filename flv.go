@@ -0,0 +1,318 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// FLV file consists of the header and the tags, each of which is followed by its size:
+//
+// +------------+------------------+-------+------------------+-------+-----
+// | FLV header | PreviousTagSize0 | Tag 1 | PreviousTagSize1 | Tag 2 | ...
+// |  (9 bytes) | (4 bytes, 0)     |       | (4 bytes)        |       |
+// +------------+------------------+-------+------------------+-------+-----
+//
+// The tag header is followed by the payload of the audio, video or data message:
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |   Tag Type    |                   Data Size                   |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                   Timestamp                   | Timestamp Ext |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                   Stream ID (always 0)                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+
+const (
+	flvHeaderSize    = 9
+	flvTagHeaderSize = 11
+
+	flvFlagVideo = 0x01
+	flvFlagAudio = 0x04
+)
+
+// FLVWriter writes audio, video and data messages as FLV tags.
+type FLVWriter struct {
+	w             io.Writer
+	headerWritten bool
+}
+
+// NewFLVWriter returns a new FLVWriter. The FLV header is written before the first tag.
+func NewFLVWriter(w io.Writer) *FLVWriter {
+	return &FLVWriter{w: w}
+}
+
+func (fw *FLVWriter) writeHeader() error {
+	x := make([]byte, flvHeaderSize+4)
+	copy(x, "FLV")
+	x[3] = 1 // version
+	x[4] = flvFlagAudio | flvFlagVideo
+	binary.BigEndian.PutUint32(x[5:9], flvHeaderSize)
+	// PreviousTagSize0 is always 0.
+	_, err := fw.w.Write(x)
+	return err
+}
+
+// WriteTag writes the payload of the message as a tag with the timestamp.
+// The type should be MessageAudio, MessageVideo or MessageDataAMF0.
+func (fw *FLVWriter) WriteTag(typeID MessageType, timestamp uint32, payload []byte) error {
+	if !fw.headerWritten {
+		if err := fw.writeHeader(); err != nil {
+			return err
+		}
+		fw.headerWritten = true
+	}
+
+	x := make([]byte, flvTagHeaderSize, flvTagHeaderSize+len(payload)+4)
+	x[0] = uint8(typeID)
+	putUint24(x[1:4], uint32(len(payload)))
+	putUint24(x[4:7], timestamp&0xffffff)
+	x[7] = uint8(timestamp >> 24)
+	// Stream ID is always 0.
+	x = append(x, payload...)
+	x = x[:len(x)+4]
+	binary.BigEndian.PutUint32(x[len(x)-4:], uint32(flvTagHeaderSize+len(payload)))
+	_, err := fw.w.Write(x)
+	return err
+}
+
+// WriteMessage writes the message as a tag.
+func (fw *FLVWriter) WriteMessage(msg *Message) error {
+	return fw.WriteTag(msg.TypeID, msg.Timestamp, msg.Payload)
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = uint8(v >> 16)
+	b[1] = uint8(v >> 8)
+	b[2] = uint8(v)
+}
+
+var errInvalidFLV = errors.New("invalid flv")
+
+// FLVReader reads the tags of FLV as audio, video and data messages.
+type FLVReader struct {
+	r io.Reader
+}
+
+// NewFLVReader reads the FLV header and returns a new FLVReader.
+func NewFLVReader(r io.Reader) (*FLVReader, error) {
+	dataOffset, err := readFLVHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	// Skip the rest of the header and PreviousTagSize0.
+	if _, err := io.CopyN(ioutil.Discard, r, int64(dataOffset-flvHeaderSize)+4); err != nil {
+		return nil, err
+	}
+	return &FLVReader{r: r}, nil
+}
+
+// readFLVHeader reads the FLV header and returns the offset of the first PreviousTagSize.
+func readFLVHeader(r io.Reader) (uint32, error) {
+	x := make([]byte, flvHeaderSize)
+	if _, err := io.ReadFull(r, x); err != nil {
+		return 0, err
+	}
+	if string(x[:3]) != "FLV" {
+		return 0, errInvalidFLV
+	}
+	dataOffset := binary.BigEndian.Uint32(x[5:9])
+	if dataOffset < flvHeaderSize {
+		return 0, errInvalidFLV
+	}
+	return dataOffset, nil
+}
+
+// ReadMessage reads the next tag as a message.
+// It returns io.EOF if there are no more tags.
+func (fr *FLVReader) ReadMessage() (*Message, error) {
+	x := make([]byte, flvTagHeaderSize)
+	if _, err := io.ReadFull(fr.r, x); err != nil {
+		return nil, err
+	}
+	size := flvTagSize(x)
+	msg := &Message{
+		TypeID:    flvTagType(x),
+		Timestamp: flvTagTimestamp(x),
+		Payload:   make([]byte, size+4),
+	}
+	if _, err := io.ReadFull(fr.r, msg.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	// Drop PreviousTagSize.
+	msg.Payload = msg.Payload[:size]
+	return msg, nil
+}
+
+// flvTagType returns the type of the tag header.
+func flvTagType(x []byte) MessageType {
+	return MessageType(x[0] & 0x1f) // The upper bits are reserved or used for the encryption.
+}
+
+// flvTagSize returns the payload size of the tag header.
+func flvTagSize(x []byte) uint32 {
+	return uint32(x[1])<<16 | uint32(x[2])<<8 | uint32(x[3])
+}
+
+// flvTagTimestamp returns the timestamp of the tag header.
+func flvTagTimestamp(x []byte) uint32 {
+	return uint32(x[7])<<24 | uint32(x[4])<<16 | uint32(x[5])<<8 | uint32(x[6])
+}
+
+// flvKeyframe is an entry of the keyframe index of an FLV file.
+type flvKeyframe struct {
+	timestamp uint32 // in milliseconds.
+	offset    int64  // of the tag header.
+}
+
+// flvIndex is the keyframe index of an FLV file in timestamp order.
+type flvIndex []flvKeyframe
+
+// readFLVIndex returns the keyframe index of the FLV file.
+// The index in onMetaData, which is added by tools like yamdi and flvtool2, is used if the first tag has it.
+// Otherwise the index is built by scanning the tag headers.
+// The offset of rs is undefined after it returns.
+func readFLVIndex(rs io.ReadSeeker) (flvIndex, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dataOffset, err := readFLVHeader(rs)
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(dataOffset) + 4
+	if _, err = rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	msg, err := (&FLVReader{r: rs}).ReadMessage()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if msg.TypeID == MessageDataAMF0 && isMetadata(msg.Payload) {
+		if m, err := ParseMetadata(msg.Payload); err == nil {
+			if index := metadataKeyframes(m); index != nil {
+				return index, nil
+			}
+		}
+	}
+	return scanFLVIndex(rs, offset)
+}
+
+// readFLVHeaderTags returns onMetaData and the sequence headers at the start of the FLV file,
+// which are read until the first audio or video frame.
+// The offset of rs is undefined after it returns.
+func readFLVHeaderTags(rs io.ReadSeeker) ([]*Message, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	fr, err := NewFLVReader(rs)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*Message
+	for {
+		msg, err := fr.ReadMessage()
+		if err == io.EOF {
+			return msgs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case msg.TypeID == MessageDataAMF0:
+			if isMetadata(msg.Payload) {
+				msgs = append(msgs, msg)
+			}
+		case msg.TypeID == MessageVideo && isVideoSequenceHeader(msg.Payload),
+			msg.TypeID == MessageAudio && isAudioSequenceHeader(msg.Payload):
+			msgs = append(msgs, msg)
+		case msg.TypeID == MessageVideo, msg.TypeID == MessageAudio:
+			return msgs, nil
+		}
+	}
+}
+
+// metadataKeyframes returns the index of the keyframes property of onMetaData:
+//
+//	"keyframes": {"times": [0, 2.002, ...], "filepositions": [13, 95210, ...]}
+//
+// It returns nil if onMetaData doesn't have a valid index.
+func metadataKeyframes(m *StreamMetadata) flvIndex {
+	var keyframes map[string]interface{}
+	switch obj := m.Properties["keyframes"].(type) {
+	case amf.Object:
+		keyframes = obj
+	case map[string]interface{}:
+		keyframes = obj
+	default:
+		return nil
+	}
+	times, _ := keyframes["times"].([]interface{})
+	positions, _ := keyframes["filepositions"].([]interface{})
+	if len(times) == 0 || len(times) != len(positions) {
+		return nil
+	}
+	index := make(flvIndex, len(times))
+	for i := range times {
+		t, ok := times[i].(float64)
+		if !ok || t < 0 {
+			return nil
+		}
+		pos, ok := positions[i].(float64)
+		if !ok || pos < flvHeaderSize+4 {
+			return nil
+		}
+		index[i] = flvKeyframe{timestamp: uint32(t * 1000), offset: int64(pos)}
+	}
+	sort.SliceStable(index, func(i, j int) bool { return index[i].timestamp < index[j].timestamp })
+	return index
+}
+
+// scanFLVIndex builds the keyframe index by reading the tag headers from the offset to the end.
+func scanFLVIndex(rs io.ReadSeeker, offset int64) (flvIndex, error) {
+	var index flvIndex
+	// The tag header and the first 2 bytes of the payload, which tell whether it's a keyframe.
+	x := make([]byte, flvTagHeaderSize+2)
+	for {
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		n, err := io.ReadFull(rs, x)
+		if n < flvTagHeaderSize {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return index, nil
+			}
+			return nil, err
+		}
+		size := flvTagSize(x)
+		payload := x[flvTagHeaderSize:n]
+		if uint32(len(payload)) > size {
+			payload = payload[:size]
+		}
+		if flvTagType(x) == MessageVideo && isVideoKeyframe(payload) {
+			index = append(index, flvKeyframe{timestamp: flvTagTimestamp(x), offset: offset})
+		}
+		offset += flvTagHeaderSize + int64(size) + 4
+	}
+}
+
+// lookup returns the last keyframe at or before the timestamp, or the first keyframe
+// if there are no such keyframes. The index must not be empty.
+func (index flvIndex) lookup(timestamp uint32) flvKeyframe {
+	i := sort.Search(len(index), func(i int) bool { return index[i].timestamp > timestamp })
+	if i > 0 {
+		i--
+	}
+	return index[i]
+}
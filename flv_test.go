@@ -0,0 +1,110 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+func TestFLVWriterWriteTag(t *testing.T) {
+	out := new(bytes.Buffer)
+	fw := NewFLVWriter(out)
+	if err := fw.WriteTag(MessageVideo, 0x01020304, []byte{0x17, 0x01}); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	expected := []byte{
+		0x46, 0x4c, 0x56, 0x01, 0x05, 0x00, 0x00, 0x00, 0x09, // FLV header
+		0x00, 0x00, 0x00, 0x00, // PreviousTagSize0
+		0x09, 0x00, 0x00, 0x02, 0x02, 0x03, 0x04, 0x01, 0x00, 0x00, 0x00, // tag header
+		0x17, 0x01, // payload
+		0x00, 0x00, 0x00, 0x0d, // PreviousTagSize1
+	}
+	if bytes.Compare(expected, out.Bytes()) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expected, out.Bytes())
+	}
+}
+
+func TestFLVReader(t *testing.T) {
+	out := new(bytes.Buffer)
+	fw := NewFLVWriter(out)
+	expected := []*Message{
+		{TypeID: MessageVideo, Timestamp: 0, Payload: []byte{0x17, 0x00}},
+		{TypeID: MessageAudio, Timestamp: 0x01020304, Payload: []byte{0xaf, 0x01, 0x02}},
+	}
+	for _, msg := range expected {
+		fw.WriteMessage(msg)
+	}
+
+	fr, err := NewFLVReader(out)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	for _, e := range expected {
+		actual, err := fr.ReadMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if !reflect.DeepEqual(e, actual) {
+			t.Errorf("Should be %#v, but got %#v", e, actual)
+		}
+	}
+	if _, err = fr.ReadMessage(); err != io.EOF {
+		t.Errorf("Should be EOF, but got %v", err)
+	}
+
+	if _, err = NewFLVReader(bytes.NewBufferString("MP4\x01\x05\x00\x00\x00\x09")); err != errInvalidFLV {
+		t.Errorf("Should be %s, but got %v", errInvalidFLV, err)
+	}
+}
+
+func TestReadFLVIndex(t *testing.T) {
+	flv := new(bytes.Buffer)
+	fw := NewFLVWriter(flv)
+	fw.WriteTag(MessageVideo, 0, []byte{0x17, 0x00}) // sequence header at 13
+	fw.WriteTag(MessageVideo, 0, []byte{0x17, 0x01}) // keyframe at 30
+	fw.WriteTag(MessageAudio, 20, []byte{0xaf, 0x01})
+	fw.WriteTag(MessageVideo, 40, []byte{0x27, 0x01})
+	fw.WriteTag(MessageVideo, 2000, []byte{0x17, 0x01}) // keyframe at 81
+
+	index, err := readFLVIndex(bytes.NewReader(flv.Bytes()))
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	expected := flvIndex{{timestamp: 0, offset: 30}, {timestamp: 2000, offset: 81}}
+	if !reflect.DeepEqual(index, expected) {
+		t.Errorf("Should be %#v, but got %#v", expected, index)
+	}
+	if k := index.lookup(1999); k != expected[0] {
+		t.Errorf("Should be %#v, but got %#v", expected[0], k)
+	}
+	if k := index.lookup(2000); k != expected[1] {
+		t.Errorf("Should be %#v, but got %#v", expected[1], k)
+	}
+}
+
+func TestReadFLVIndexFromMetadata(t *testing.T) {
+	metadata := new(bytes.Buffer)
+	amf.WriteString(metadata, "onMetaData")
+	amf.WriteValue(metadata, amf.Object{
+		"keyframes": amf.Object{
+			"times":         []interface{}{0.0, 2.5},
+			"filepositions": []interface{}{100.0, 5000.0},
+		},
+	})
+	flv := new(bytes.Buffer)
+	fw := NewFLVWriter(flv)
+	fw.WriteTag(MessageDataAMF0, 0, metadata.Bytes())
+	fw.WriteTag(MessageVideo, 0, []byte{0x17, 0x01})
+
+	index, err := readFLVIndex(bytes.NewReader(flv.Bytes()))
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	expected := flvIndex{{timestamp: 0, offset: 100}, {timestamp: 2500, offset: 5000}}
+	if !reflect.DeepEqual(index, expected) {
+		t.Errorf("Should be %#v, but got %#v", expected, index)
+	}
+}
@@ -0,0 +1,116 @@
+package rtmp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// A Handler responds to the events of RTMP connections.
+//
+// OnConnect, OnPublish and OnPlay reject the request by returning a non-nil error.
+// The error is sent to the peer as the description of the status event.
+// If OnAudio, OnVideo or OnData returns a non-nil error, the connection is closed.
+//
+// The methods of a Handler are called from the goroutine reading the connection,
+// so they should not block for a long time.
+type Handler interface {
+	// OnConnect is called when the peer sends a connect command.
+	// params is the command object of the connect command.
+	OnConnect(c *Conn, app, tcURL string, params map[string]interface{}) error
+	// OnPublish is called when the peer starts publishing the stream.
+	OnPublish(c *Conn, streamKey string) error
+	// OnPlay is called when the peer requests to play the stream.
+	OnPlay(c *Conn, streamKey string) error
+	// OnAudio is called for each audio message published by the peer.
+	OnAudio(c *Conn, msg *Message) error
+	// OnVideo is called for each video message published by the peer.
+	OnVideo(c *Conn, msg *Message) error
+	// OnData is called for each data message (AMF0) sent by the peer.
+	OnData(c *Conn, msg *Message) error
+	// OnMetadata is called when the publisher sends onMetaData.
+	OnMetadata(c *Conn, s *Stream, metadata *StreamMetadata)
+	// OnSequenceHeader is called when the publisher sends an audio or video sequence header.
+	// changed is true if it differs from the previous one, which means the encoder settings
+	// are changed mid-stream. The parsed configurations are available from the stream.
+	OnSequenceHeader(c *Conn, s *Stream, changed bool)
+	// OnClose is called after the connection accepted by OnConnect is closed.
+	OnClose(c *Conn)
+}
+
+// NopHandler accepts all requests and discards all media.
+// It can be embedded into a struct to implement only some methods of Handler.
+type NopHandler struct{}
+
+func (NopHandler) OnConnect(c *Conn, app, tcURL string, params map[string]interface{}) error {
+	return nil
+}
+func (NopHandler) OnPublish(c *Conn, streamKey string) error               { return nil }
+func (NopHandler) OnPlay(c *Conn, streamKey string) error                  { return nil }
+func (NopHandler) OnAudio(c *Conn, msg *Message) error                     { return nil }
+func (NopHandler) OnVideo(c *Conn, msg *Message) error                     { return nil }
+func (NopHandler) OnData(c *Conn, msg *Message) error                      { return nil }
+func (NopHandler) OnMetadata(c *Conn, s *Stream, metadata *StreamMetadata) {}
+func (NopHandler) OnSequenceHeader(c *Conn, s *Stream, changed bool)       {}
+func (NopHandler) OnClose(c *Conn)                                         {}
+
+// Conn is the handle of an RTMP connection passed to a Handler.
+// It is safe to call its methods from multiple goroutines.
+type Conn struct {
+	c *conn
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.c.netconn.RemoteAddr()
+}
+
+// App returns the application name sent by the connect command.
+func (c *Conn) App() string {
+	c.c.smu.Lock()
+	defer c.c.smu.Unlock()
+	return c.c.app
+}
+
+// TCURL returns the tcUrl sent by the connect command.
+func (c *Conn) TCURL() string {
+	c.c.smu.Lock()
+	defer c.c.smu.Unlock()
+	return c.c.tcURL
+}
+
+// StreamKey returns the name of the stream which is published or played.
+func (c *Conn) StreamKey() string {
+	c.c.smu.Lock()
+	defer c.c.smu.Unlock()
+	return c.c.streamKey
+}
+
+// RoundTripTime returns the round-trip time measured by the last PingResponse event.
+func (c *Conn) RoundTripTime() time.Duration {
+	return c.c.roundTripTime()
+}
+
+// BufferLength returns the buffer length sent by the last SetBufferLength event of the player.
+// It is 0 if the player hasn't sent it.
+func (c *Conn) BufferLength() time.Duration {
+	return time.Duration(atomic.LoadUint32(&c.c.bufferLength)) * time.Millisecond
+}
+
+// UnacknowledgedBytes returns the bytes sent to the peer but not acknowledged by its last
+// Acknowledgement message. It shows how far behind a slow player is, if the peer sends
+// Acknowledgement messages per the window size sent by the server.
+func (c *Conn) UnacknowledgedBytes() uint32 {
+	return c.c.unacknowledgedBytes()
+}
+
+// Abort sends an Abort message to notify the peer that it should discard the partially received
+// message on the chunk stream, e.g. when the server gives up sending a large message.
+func (c *Conn) Abort(csid uint32) error {
+	return c.c.abort(csid)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.c.netconn.Close()
+}
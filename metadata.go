@@ -0,0 +1,131 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+var errInvalidMetadata = errors.New("invalid metadata")
+
+// StreamMetadata is the metadata of the stream sent by the publisher as onMetaData.
+// Fields which are not sent by the publisher are zero.
+type StreamMetadata struct {
+	Duration        float64 // in seconds. 0 for live streams.
+	Width           float64
+	Height          float64
+	FrameRate       float64
+	VideoCodecID    VideoCodec
+	VideoDataRate   float64 // in kbps.
+	AudioCodecID    AudioFormat
+	AudioDataRate   float64 // in kbps.
+	AudioSampleRate float64 // in Hz.
+	AudioSampleSize float64 // in bits.
+	AudioChannels   float64
+	Stereo          bool
+	Encoder         string
+	FileSize        float64 // in bytes.
+
+	// Properties has all properties of onMetaData including the fields above.
+	Properties map[string]interface{}
+}
+
+// The publisher sends onMetaData wrapped by @setDataFrame, which asks the server
+// to send onMetaData to the players:
+//
+//	"@setDataFrame", "onMetaData", {"width": 1280, "height": 720, ...}
+//
+// The players receive it without the wrapper:
+//
+//	"onMetaData", {"width": 1280, "height": 720, ...}
+var setDataFrame = func() []byte {
+	buf := new(bytes.Buffer)
+	amf.WriteString(buf, "@setDataFrame")
+	return buf.Bytes()
+}()
+
+// ParseMetadata parses the payload of the data message which is onMetaData or @setDataFrame.
+func ParseMetadata(payload []byte) (*StreamMetadata, error) {
+	payload = bytes.TrimPrefix(payload, setDataFrame)
+	buf := bytes.NewBuffer(payload)
+	name, err := amf.ReadString(buf)
+	if err != nil {
+		return nil, err
+	}
+	if name != "onMetaData" {
+		return nil, errInvalidMetadata
+	}
+	v, err := amf.ReadValue(buf)
+	if err != nil {
+		return nil, err
+	}
+	var props map[string]interface{}
+	switch obj := v.(type) {
+	case amf.Object:
+		props = obj
+	case map[string]interface{}:
+		props = obj
+	default:
+		return nil, errInvalidMetadata
+	}
+
+	number := func(key string) float64 {
+		f, _ := props[key].(float64)
+		return f
+	}
+	m := &StreamMetadata{
+		Duration:        number("duration"),
+		Width:           number("width"),
+		Height:          number("height"),
+		FrameRate:       number("framerate"),
+		VideoCodecID:    VideoCodec(number("videocodecid")),
+		VideoDataRate:   number("videodatarate"),
+		AudioCodecID:    AudioFormat(number("audiocodecid")),
+		AudioDataRate:   number("audiodatarate"),
+		AudioSampleRate: number("audiosamplerate"),
+		AudioSampleSize: number("audiosamplesize"),
+		AudioChannels:   number("audiochannels"),
+		FileSize:        number("filesize"),
+		Properties:      props,
+	}
+	if m.FrameRate == 0 {
+		// Some encoders send fps instead of framerate.
+		m.FrameRate = number("fps")
+	}
+	m.Stereo, _ = props["stereo"].(bool)
+	m.Encoder, _ = props["encoder"].(string)
+	return m, nil
+}
+
+// onMetaDataMessage returns the onMetaData message sent to the players,
+// which removes @setDataFrame from the data message.
+func onMetaDataMessage(msg *Message) *Message {
+	if !bytes.HasPrefix(msg.Payload, setDataFrame) {
+		return msg
+	}
+	return &Message{
+		ChunkStreamID: msg.ChunkStreamID,
+		Timestamp:     msg.Timestamp,
+		TypeID:        msg.TypeID,
+		StreamID:      msg.StreamID,
+		Payload:       msg.Payload[len(setDataFrame):],
+	}
+}
+
+// setDataFrameMessage returns the metadata message sent to the upstream server,
+// which wraps onMetaData by @setDataFrame.
+func setDataFrameMessage(msg *Message) *Message {
+	if bytes.HasPrefix(msg.Payload, setDataFrame) {
+		return msg
+	}
+	payload := make([]byte, 0, len(setDataFrame)+len(msg.Payload))
+	payload = append(payload, setDataFrame...)
+	return &Message{
+		ChunkStreamID: msg.ChunkStreamID,
+		Timestamp:     msg.Timestamp,
+		TypeID:        msg.TypeID,
+		StreamID:      msg.StreamID,
+		Payload:       append(payload, msg.Payload...),
+	}
+}
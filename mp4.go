@@ -0,0 +1,743 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// MP4 (ISO BMFF) file consists of boxes, each of which begins with its size and type:
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                  Size (including the header)                  |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                     Type (e.g. "moov")                        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |              Large Size (64 bits, only if Size is 1)          |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// The samples are stored in mdat, and moov has the tracks which describe them:
+//
+//	moov
+//	└── trak
+//	    └── mdia
+//	        ├── mdhd (timescale)
+//	        ├── hdlr (vide or soun)
+//	        └── minf
+//	            └── stbl
+//	                ├── stsd (avc1 with avcC, or mp4a with esds)
+//	                ├── stts (decoding time)
+//	                ├── ctts (composition time offset)
+//	                ├── stss (sync samples)
+//	                ├── stsc (samples per chunk)
+//	                ├── stsz (sample size)
+//	                └── stco or co64 (chunk offset)
+
+const (
+	maxMP4MoovSize    = 64 << 20
+	maxMP4SampleCount = 1 << 24
+	maxMP4SampleSize  = 64 << 20
+)
+
+var (
+	errInvalidMP4     = errors.New("invalid mp4")
+	errUnsupportedMP4 = errors.New("mp4 has no H.264 or AAC tracks")
+)
+
+// MP4Reader reads the first H.264 and AAC tracks of an MP4 file as audio and video messages.
+// The other tracks are ignored.
+//
+// onMetaData and the sequence headers are read first, and then the samples in decoding time order.
+// Edit lists are not applied.
+type MP4Reader struct {
+	r       io.ReadSeeker
+	tracks  []*mp4Track
+	pending []*Message // onMetaData and the sequence headers.
+}
+
+type mp4Track struct {
+	typeID    MessageType // MessageVideo or MessageAudio.
+	timescale uint32
+	config    []byte // AVCDecoderConfigurationRecord or AudioSpecificConfig.
+	samples   []mp4Sample
+	next      int // the index of the next sample.
+
+	width, height uint16 // only for video.
+	channels      uint16 // only for audio.
+	sampleRate    int    // only for audio.
+}
+
+type mp4Sample struct {
+	offset int64
+	size   uint32
+	dts    uint64 // in the timescale of the track.
+	cto    int32  // composition time offset in the timescale of the track.
+	sync   bool
+}
+
+// NewMP4Reader reads moov of the MP4 file and returns a new MP4Reader.
+func NewMP4Reader(r io.ReadSeeker) (*MP4Reader, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	moov, err := readMP4Moov(r)
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := readMP4Boxes(moov)
+	if err != nil {
+		return nil, err
+	}
+	mr := &MP4Reader{r: r}
+	seen := make(map[MessageType]bool)
+	for _, box := range boxes {
+		if box.typ != "trak" {
+			continue
+		}
+		track, err := parseMP4Track(box.body, fileSize)
+		if err != nil {
+			return nil, err
+		}
+		if track != nil && len(track.samples) > 0 && !seen[track.typeID] {
+			seen[track.typeID] = true
+			mr.tracks = append(mr.tracks, track)
+		}
+	}
+	if len(mr.tracks) == 0 {
+		return nil, errUnsupportedMP4
+	}
+	// Video first so that it's the primary track of Seek.
+	sort.SliceStable(mr.tracks, func(i, j int) bool {
+		return mr.tracks[i].typeID == MessageVideo && mr.tracks[j].typeID != MessageVideo
+	})
+
+	mr.pending = append(mr.pending, mr.metadataMessage())
+	for _, track := range mr.tracks {
+		mr.pending = append(mr.pending, track.sequenceHeaderMessage())
+	}
+	return mr, nil
+}
+
+// ReadMessage returns onMetaData, the sequence headers and then the next sample.
+// It returns io.EOF if there are no more samples.
+func (mr *MP4Reader) ReadMessage() (*Message, error) {
+	if len(mr.pending) > 0 {
+		msg := mr.pending[0]
+		mr.pending = mr.pending[1:]
+		return msg, nil
+	}
+
+	var track *mp4Track
+	for _, t := range mr.tracks {
+		if t.next >= len(t.samples) {
+			continue
+		}
+		if track == nil || t.ms(t.samples[t.next].dts) < track.ms(track.samples[track.next].dts) {
+			track = t
+		}
+	}
+	if track == nil {
+		return nil, io.EOF
+	}
+	sample := track.samples[track.next]
+	track.next++
+
+	var header []byte
+	if track.typeID == MessageVideo {
+		var frameType VideoFrameType = VideoFrameInter
+		if sample.sync {
+			frameType = VideoFrameKey
+		}
+		cto := int64(sample.cto) * 1000 / int64(track.timescale)
+		header = []byte{byte(frameType)<<4 | byte(VideoCodecAVC), 1, byte(cto >> 16), byte(cto >> 8), byte(cto)}
+	} else {
+		header = []byte{aacTagHeader, 1}
+	}
+	if sample.size > maxMP4SampleSize {
+		return nil, errInvalidMP4
+	}
+	payload := make([]byte, len(header)+int(sample.size))
+	copy(payload, header)
+	if _, err := mr.r.Seek(sample.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(mr.r, payload[len(header):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &Message{
+		TypeID:    track.typeID,
+		Timestamp: uint32(track.ms(sample.dts)),
+		Payload:   payload,
+	}, nil
+}
+
+// Seek moves to the last sync sample at or before the time in milliseconds of the video track,
+// or the audio track if there is no video track, and returns its timestamp.
+// The other track moves to the first sample at or after the timestamp.
+func (mr *MP4Reader) Seek(ms uint32) (uint32, error) {
+	primary := mr.tracks[0]
+	i := sort.Search(len(primary.samples), func(i int) bool {
+		return primary.ms(primary.samples[i].dts) > uint64(ms)
+	})
+	for i > 0 && !primary.samples[i-1].sync {
+		i--
+	}
+	if i > 0 {
+		i--
+	} else {
+		// Seeking before the first sync sample starts from the first one.
+		for i < len(primary.samples)-1 && !primary.samples[i].sync {
+			i++
+		}
+	}
+	primary.next = i
+	timestamp := primary.ms(primary.samples[i].dts)
+
+	for _, t := range mr.tracks[1:] {
+		t.next = sort.Search(len(t.samples), func(i int) bool {
+			return t.ms(t.samples[i].dts) >= timestamp
+		})
+	}
+	// onMetaData and the sequence headers not read yet are sent at the time of the sync sample.
+	for _, msg := range mr.pending {
+		msg.Timestamp = uint32(timestamp)
+	}
+	return uint32(timestamp), nil
+}
+
+// aacTagHeader is the first byte of the audio tag header of AAC,
+// which is always 44 kHz, 16 bits and stereo. The actual values are in AudioSpecificConfig.
+const aacTagHeader = byte(AudioFormatAAC)<<4 | 0x0f
+
+func (t *mp4Track) ms(v uint64) uint64 {
+	return v * 1000 / uint64(t.timescale)
+}
+
+func (t *mp4Track) sequenceHeaderMessage() *Message {
+	var header []byte
+	if t.typeID == MessageVideo {
+		header = []byte{byte(VideoFrameKey)<<4 | byte(VideoCodecAVC), 0, 0, 0, 0}
+	} else {
+		header = []byte{aacTagHeader, 0}
+	}
+	return &Message{
+		TypeID:  t.typeID,
+		Payload: append(header, t.config...),
+	}
+}
+
+// metadataMessage returns onMetaData which has the duration and the codecs of the tracks.
+func (mr *MP4Reader) metadataMessage() *Message {
+	props := amf.Object{}
+	var duration uint64
+	for _, t := range mr.tracks {
+		last := t.samples[len(t.samples)-1]
+		if d := t.ms(last.dts); d > duration {
+			duration = d
+		}
+		if t.typeID == MessageVideo {
+			props["videocodecid"] = float64(VideoCodecAVC)
+			props["width"] = float64(t.width)
+			props["height"] = float64(t.height)
+		} else {
+			props["audiocodecid"] = float64(AudioFormatAAC)
+			props["audiosamplerate"] = float64(t.sampleRate)
+			props["audiochannels"] = float64(t.channels)
+			props["stereo"] = t.channels == 2
+		}
+	}
+	props["duration"] = float64(duration) / 1000
+	props["canSeekToEnd"] = true
+
+	buf := new(bytes.Buffer)
+	amf.WriteString(buf, "onMetaData")
+	amf.WriteObject(buf, props)
+	return &Message{
+		TypeID:  MessageDataAMF0,
+		Payload: buf.Bytes(),
+	}
+}
+
+// readMP4Moov returns the body of moov in the top level boxes.
+func readMP4Moov(r io.ReadSeeker) ([]byte, error) {
+	var offset int64
+	x := make([]byte, 16)
+	for {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, x[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errInvalidMP4 // no moov
+			}
+			return nil, err
+		}
+		size := uint64(binary.BigEndian.Uint32(x[0:4]))
+		typ := string(x[4:8])
+		headerSize := uint64(8)
+		if size == 1 {
+			if _, err := io.ReadFull(r, x[8:16]); err != nil {
+				return nil, errInvalidMP4
+			}
+			size = binary.BigEndian.Uint64(x[8:16])
+			headerSize = 16
+		}
+
+		if typ == "moov" {
+			var body []byte
+			var err error
+			if size == 0 {
+				// The box extends to the end of the file.
+				body, err = io.ReadAll(io.LimitReader(r, maxMP4MoovSize))
+			} else if size < headerSize || size-headerSize > maxMP4MoovSize {
+				return nil, errInvalidMP4
+			} else {
+				body = make([]byte, size-headerSize)
+				_, err = io.ReadFull(r, body)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errInvalidMP4
+			}
+			return body, err
+		}
+		if size == 0 || size < headerSize || size > 1<<62 {
+			return nil, errInvalidMP4
+		}
+		offset += int64(size)
+	}
+}
+
+type mp4Box struct {
+	typ  string
+	body []byte
+}
+
+// readMP4Boxes returns the boxes in b.
+func readMP4Boxes(b []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errInvalidMP4
+		}
+		size := uint64(binary.BigEndian.Uint32(b[0:4]))
+		typ := string(b[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, errInvalidMP4
+			}
+			size = binary.BigEndian.Uint64(b[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			return nil, errInvalidMP4
+		}
+		boxes = append(boxes, mp4Box{typ: typ, body: b[headerSize:size]})
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+// findMP4Box returns the body of the box at the path of the types in b.
+// It returns nil if there is no such box.
+func findMP4Box(b []byte, path ...string) ([]byte, error) {
+	for _, typ := range path {
+		boxes, err := readMP4Boxes(b)
+		if err != nil {
+			return nil, err
+		}
+		b = nil
+		for _, box := range boxes {
+			if box.typ == typ {
+				b = box.body
+				break
+			}
+		}
+		if b == nil {
+			return nil, nil
+		}
+	}
+	return b, nil
+}
+
+// parseMP4Track returns the track of trak in the file of the size.
+// It returns nil if the codec is not supported.
+func parseMP4Track(trak []byte, fileSize int64) (*mp4Track, error) {
+	mdhd, err := findMP4Box(trak, "mdia", "mdhd")
+	if err != nil {
+		return nil, err
+	}
+	hdlr, err := findMP4Box(trak, "mdia", "hdlr")
+	if err != nil {
+		return nil, err
+	}
+	stbl, err := findMP4Box(trak, "mdia", "minf", "stbl")
+	if err != nil {
+		return nil, err
+	}
+	if len(mdhd) < 24 || len(hdlr) < 12 || stbl == nil {
+		return nil, errInvalidMP4
+	}
+
+	t := &mp4Track{}
+	if mdhd[0] == 1 { // version
+		if len(mdhd) < 32 {
+			return nil, errInvalidMP4
+		}
+		t.timescale = binary.BigEndian.Uint32(mdhd[20:24])
+	} else {
+		t.timescale = binary.BigEndian.Uint32(mdhd[12:16])
+	}
+	if t.timescale == 0 {
+		return nil, errInvalidMP4
+	}
+
+	stsd, err := findMP4Box(stbl, "stsd")
+	if err != nil {
+		return nil, err
+	}
+	if len(stsd) < 8 {
+		return nil, errInvalidMP4
+	}
+	entries, err := readMP4Boxes(stsd[8:])
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errInvalidMP4
+	}
+	entry := entries[0]
+
+	switch string(hdlr[8:12]) {
+	case "vide":
+		if entry.typ != "avc1" && entry.typ != "avc3" {
+			return nil, nil
+		}
+		if err = t.parseVisualSampleEntry(entry.body); err != nil {
+			return nil, err
+		}
+	case "soun":
+		if entry.typ != "mp4a" {
+			return nil, nil
+		}
+		if err = t.parseAudioSampleEntry(entry.body); err != nil {
+			return nil, err
+		}
+		if t.config == nil {
+			return nil, nil // not AAC
+		}
+	default:
+		return nil, nil
+	}
+
+	if t.samples, err = readMP4Samples(stbl, fileSize); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseVisualSampleEntry parses avc1 which has the fixed fields of 78 bytes followed by avcC.
+func (t *mp4Track) parseVisualSampleEntry(b []byte) error {
+	if len(b) < 78 {
+		return errInvalidMP4
+	}
+	t.typeID = MessageVideo
+	t.width = binary.BigEndian.Uint16(b[24:26])
+	t.height = binary.BigEndian.Uint16(b[26:28])
+	avcC, err := findMP4Box(b[78:], "avcC")
+	if err != nil {
+		return err
+	}
+	if avcC == nil {
+		return errInvalidMP4
+	}
+	t.config = avcC
+	return nil
+}
+
+// parseAudioSampleEntry parses mp4a which has the fixed fields of 28 bytes followed by esds.
+// The sound sample description of QuickTime version 1 and 2 has 16 and 36 more bytes.
+func (t *mp4Track) parseAudioSampleEntry(b []byte) error {
+	if len(b) < 28 {
+		return errInvalidMP4
+	}
+	t.typeID = MessageAudio
+	t.channels = binary.BigEndian.Uint16(b[16:18])
+	// 16.16 fixed-point, which can't have 88.2 kHz or more. The rate of AudioSpecificConfig is used if any.
+	t.sampleRate = int(binary.BigEndian.Uint16(b[24:26]))
+	n := 28
+	switch binary.BigEndian.Uint16(b[8:10]) {
+	case 1:
+		n += 16
+	case 2:
+		n += 36
+	}
+	if len(b) < n {
+		return errInvalidMP4
+	}
+	esds, err := findMP4Box(b[n:], "esds")
+	if err != nil {
+		return err
+	}
+	if esds == nil {
+		return errInvalidMP4
+	}
+	if t.config, err = parseESDS(esds); err != nil || t.config == nil {
+		return err
+	}
+	if config, err := ParseAudioSequenceHeader(t.sequenceHeaderMessage().Payload); err == nil {
+		t.sampleRate = config.SampleRate
+	}
+	return nil
+}
+
+// parseESDS returns AudioSpecificConfig in esds, or nil if it's not AAC.
+// esds has ES_Descriptor which has DecoderConfigDescriptor which has DecoderSpecificInfo:
+//
+//	ES_Descriptor (tag 3): ES_ID (2 bytes), flags (1 byte), optional fields
+//	└── DecoderConfigDescriptor (tag 4): objectTypeIndication (1 byte, 0x40 for AAC), 12 bytes
+//	    └── DecoderSpecificInfo (tag 5): AudioSpecificConfig
+func parseESDS(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errInvalidMP4
+	}
+	b = b[4:] // version and flags
+
+	es, _, err := readMP4Descriptor(b, 3)
+	if err != nil {
+		return nil, err
+	}
+	if len(es) < 3 {
+		return nil, errInvalidMP4
+	}
+	flags := es[2]
+	es = es[3:]
+	if flags&0x80 != 0 { // streamDependenceFlag
+		es = skipBytes(es, 2)
+	}
+	if flags&0x40 != 0 && len(es) > 0 { // URL_Flag
+		es = skipBytes(es, 1+int(es[0]))
+	}
+	if flags&0x20 != 0 { // OCRstreamFlag
+		es = skipBytes(es, 2)
+	}
+
+	dc, _, err := readMP4Descriptor(es, 4)
+	if err != nil {
+		return nil, err
+	}
+	if len(dc) < 13 {
+		return nil, errInvalidMP4
+	}
+	if dc[0] != 0x40 { // MPEG-4 Audio
+		return nil, nil
+	}
+	config, _, err := readMP4Descriptor(dc[13:], 5)
+	return config, err
+}
+
+func skipBytes(b []byte, n int) []byte {
+	if n > len(b) {
+		return nil
+	}
+	return b[n:]
+}
+
+// readMP4Descriptor reads the descriptor of the tag and returns its body and the rest.
+// The size is encoded in 7 bits per byte, and the most significant bit tells if the next byte follows.
+func readMP4Descriptor(b []byte, tag byte) ([]byte, []byte, error) {
+	if len(b) < 2 || b[0] != tag {
+		return nil, nil, errInvalidMP4
+	}
+	b = b[1:]
+	var size int
+	for i := 0; ; i++ {
+		if i == 4 || len(b) == 0 {
+			return nil, nil, errInvalidMP4
+		}
+		c := b[0]
+		b = b[1:]
+		size = size<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			break
+		}
+	}
+	if size > len(b) {
+		return nil, nil, errInvalidMP4
+	}
+	return b[:size], b[size:], nil
+}
+
+// mp4Table returns the entries of the full box which begins with the version, the flags and the entry count.
+func mp4Table(b []byte, entrySize int) ([]byte, int, error) {
+	if len(b) < 8 {
+		return nil, 0, errInvalidMP4
+	}
+	n := binary.BigEndian.Uint32(b[4:8])
+	if uint64(n)*uint64(entrySize) > uint64(len(b)-8) {
+		return nil, 0, errInvalidMP4
+	}
+	return b[8:], int(n), nil
+}
+
+// readMP4Samples returns the samples described by the tables in stbl of the file of the size.
+func readMP4Samples(stbl []byte, fileSize int64) ([]mp4Sample, error) {
+	boxes, err := readMP4Boxes(stbl)
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string][]byte)
+	for _, box := range boxes {
+		tables[box.typ] = box.body
+	}
+
+	// stts: (sample count, sample delta)
+	stts, sttsCount, err := mp4Table(tables["stts"], 8)
+	if err != nil {
+		return nil, err
+	}
+	var total uint64
+	for j := 0; j < sttsCount; j++ {
+		total += uint64(binary.BigEndian.Uint32(stts[8*j:]))
+	}
+
+	// stsz: sample size, sample count and the sizes if the sample size is 0.
+	// The count is bounded before allocating the samples, since a small moov can have a large count:
+	// every sample needs its decoding time in stts and its data in the file.
+	stsz := tables["stsz"]
+	if len(stsz) < 12 {
+		return nil, errInvalidMP4
+	}
+	sampleSize := binary.BigEndian.Uint32(stsz[4:8])
+	count := binary.BigEndian.Uint32(stsz[8:12])
+	if count > maxMP4SampleCount || uint64(count) > total {
+		return nil, errInvalidMP4
+	}
+	if sampleSize == 0 && uint64(count)*4 > uint64(len(stsz)-12) {
+		return nil, errInvalidMP4
+	}
+	if sampleSize > 0 && uint64(count)*uint64(sampleSize) > uint64(fileSize) {
+		return nil, errInvalidMP4
+	}
+	samples := make([]mp4Sample, count)
+	for i := range samples {
+		samples[i].size = sampleSize
+		if sampleSize == 0 {
+			samples[i].size = binary.BigEndian.Uint32(stsz[12+4*i:])
+		}
+	}
+
+	var i int
+	var dts uint64
+	for j := 0; j < sttsCount && i < len(samples); j++ {
+		c := binary.BigEndian.Uint32(stts[8*j:])
+		delta := binary.BigEndian.Uint32(stts[8*j+4:])
+		for ; c > 0 && i < len(samples); c-- {
+			samples[i].dts = dts
+			dts += uint64(delta)
+			i++
+		}
+	}
+
+	// ctts: (sample count, sample offset)
+	if ctts, ok := tables["ctts"]; ok {
+		entries, n, err := mp4Table(ctts, 8)
+		if err != nil {
+			return nil, err
+		}
+		i = 0
+		for j := 0; j < n && i < len(samples); j++ {
+			c := binary.BigEndian.Uint32(entries[8*j:])
+			offset := int32(binary.BigEndian.Uint32(entries[8*j+4:]))
+			for ; c > 0 && i < len(samples); c-- {
+				samples[i].cto = offset
+				i++
+			}
+		}
+	}
+
+	// stss: sample numbers starting from 1. All samples are sync samples if there is no stss.
+	if stss, ok := tables["stss"]; ok {
+		entries, n, err := mp4Table(stss, 4)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < n; j++ {
+			if k := binary.BigEndian.Uint32(entries[4*j:]); k >= 1 && k <= uint32(len(samples)) {
+				samples[k-1].sync = true
+			}
+		}
+	} else {
+		for i := range samples {
+			samples[i].sync = true
+		}
+	}
+
+	// stco or co64: chunk offsets
+	var chunks []int64
+	if stco, ok := tables["stco"]; ok {
+		entries, n, err := mp4Table(stco, 4)
+		if err != nil {
+			return nil, err
+		}
+		chunks = make([]int64, n)
+		for j := range chunks {
+			chunks[j] = int64(binary.BigEndian.Uint32(entries[4*j:]))
+		}
+	} else if co64, ok := tables["co64"]; ok {
+		entries, n, err := mp4Table(co64, 8)
+		if err != nil {
+			return nil, err
+		}
+		chunks = make([]int64, n)
+		for j := range chunks {
+			chunks[j] = int64(binary.BigEndian.Uint64(entries[8*j:]))
+		}
+	} else {
+		return nil, errInvalidMP4
+	}
+
+	// stsc: (first chunk starting from 1, samples per chunk, sample description index)
+	// Each entry applies to the chunks until the first chunk of the next entry.
+	entries, n, err := mp4Table(tables["stsc"], 12)
+	if err != nil {
+		return nil, err
+	}
+	i = 0
+	for j := 0; j < n; j++ {
+		first := binary.BigEndian.Uint32(entries[12*j:])
+		perChunk := binary.BigEndian.Uint32(entries[12*j+4:])
+		last := uint32(len(chunks))
+		if j+1 < n {
+			last = binary.BigEndian.Uint32(entries[12*(j+1):]) - 1
+		}
+		if first < 1 || last > uint32(len(chunks)) {
+			return nil, errInvalidMP4
+		}
+		for chunk := first; chunk <= last && i < len(samples); chunk++ {
+			offset := chunks[chunk-1]
+			for k := uint32(0); k < perChunk && i < len(samples); k++ {
+				samples[i].offset = offset
+				offset += int64(samples[i].size)
+				i++
+			}
+		}
+	}
+	if i < len(samples) {
+		return nil, errInvalidMP4
+	}
+	return samples, nil
+}
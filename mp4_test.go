@@ -0,0 +1,236 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func testMP4Box(typ string, body ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+	for _, x := range body {
+		b = append(b, x...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// testMP4Table returns the body of the full box which has the entry count and the uint32 values.
+func testMP4Table(count int, values ...uint32) []byte {
+	b := make([]byte, 8+4*len(values))
+	binary.BigEndian.PutUint32(b[4:], uint32(count))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(b[8+4*i:], v)
+	}
+	return b
+}
+
+func testMP4Track(handler string, timescale uint32, entry []byte, tables ...[]byte) []byte {
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], timescale)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+	stsd := append(testMP4Table(1), entry...)
+	return testMP4Box("trak", testMP4Box("mdia",
+		testMP4Box("mdhd", mdhd),
+		testMP4Box("hdlr", hdlr),
+		testMP4Box("minf", testMP4Box("stbl", append([][]byte{testMP4Box("stsd", stsd)}, tables...)...)),
+	))
+}
+
+var (
+	testAVCC = []byte{0x01, 0x64, 0x00, 0x1f, 0xff, 0xe1, 0x00, 0x04, 0x67, 0x64, 0x00, 0x1f, 0x01, 0x00, 0x02, 0x68, 0xee}
+	testASC  = []byte{0x12, 0x10}
+)
+
+// testMP4 returns an MP4 file which has 3 video samples at 0, 33 and 66 ms, of which the first
+// and the last are sync samples, and 2 audio samples at 0 and 23 ms.
+func testMP4() []byte {
+	ftyp := testMP4Box("ftyp", []byte("isom\x00\x00\x00\x00"))
+	mdat := testMP4Box("mdat", []byte("AAAACCCEEEEbbdd"))
+	dataOffset := uint32(len(ftyp) + 8)
+
+	avc1 := make([]byte, 78)
+	binary.BigEndian.PutUint16(avc1[24:], 1280)
+	binary.BigEndian.PutUint16(avc1[26:], 720)
+	video := testMP4Track("vide", 90000,
+		testMP4Box("avc1", avc1, testMP4Box("avcC", testAVCC)),
+		testMP4Box("stts", testMP4Table(1, 3, 3000)),
+		testMP4Box("ctts", testMP4Table(1, 3, 3000)),
+		testMP4Box("stss", testMP4Table(2, 1, 3)),
+		testMP4Box("stsc", testMP4Table(1, 1, 3, 1)),
+		testMP4Box("stsz", testMP4Table(0, 3, 4, 3, 4)), // sample size 0, count 3 and the sizes
+		testMP4Box("stco", testMP4Table(1, dataOffset)),
+	)
+
+	mp4a := make([]byte, 28)
+	binary.BigEndian.PutUint16(mp4a[16:], 2)
+	binary.BigEndian.PutUint16(mp4a[24:], 44100)
+	esds := []byte{
+		0, 0, 0, 0, // version and flags
+		0x03, 25, 0x00, 0x01, 0x00, // ES_Descriptor
+		0x04, 17, 0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // DecoderConfigDescriptor
+		0x05, 2, testASC[0], testASC[1], // DecoderSpecificInfo
+		0x06, 1, 0x02, // SLConfigDescriptor
+	}
+	audio := testMP4Track("soun", 44100,
+		testMP4Box("mp4a", mp4a, testMP4Box("esds", esds)),
+		testMP4Box("stts", testMP4Table(1, 2, 1024)),
+		testMP4Box("stsc", testMP4Table(1, 1, 2, 1)),
+		testMP4Box("stsz", testMP4Table(2, 2)), // sample size 2, count 2
+		testMP4Box("stco", testMP4Table(1, dataOffset+11)),
+	)
+
+	// The audio track comes first, but the video track is read first at the same timestamp.
+	return append(append(ftyp, mdat...), testMP4Box("moov", audio, video)...)
+}
+
+func TestMP4Reader(t *testing.T) {
+	mr, err := NewMP4Reader(bytes.NewReader(testMP4()))
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+
+	msg, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	metadata, err := ParseMetadata(msg.Payload)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if metadata.Width != 1280 || metadata.Height != 720 || metadata.AudioSampleRate != 44100 || metadata.Duration != 0.066 {
+		t.Errorf("Should be 1280x720, 44100 Hz and 0.066 s, but got %#v", metadata)
+	}
+
+	msg, _ = mr.ReadMessage()
+	video, err := ParseVideoSequenceHeader(msg.Payload)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if video.Profile != 0x64 || !reflect.DeepEqual(video.PPS, [][]byte{{0x68, 0xee}}) {
+		t.Errorf("Should be High profile with the PPS, but got %#v", video)
+	}
+	msg, _ = mr.ReadMessage()
+	audio, err := ParseAudioSequenceHeader(msg.Payload)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if audio.SampleRate != 44100 || audio.Channels != 2 {
+		t.Errorf("Should be 44100 Hz stereo, but got %#v", audio)
+	}
+
+	for _, expected := range []*Message{
+		{TypeID: MessageVideo, Timestamp: 0, Payload: []byte{0x17, 0x01, 0x00, 0x00, 33, 'A', 'A', 'A', 'A'}},
+		{TypeID: MessageAudio, Timestamp: 0, Payload: []byte{0xaf, 0x01, 'b', 'b'}},
+		{TypeID: MessageAudio, Timestamp: 23, Payload: []byte{0xaf, 0x01, 'd', 'd'}},
+		{TypeID: MessageVideo, Timestamp: 33, Payload: []byte{0x27, 0x01, 0x00, 0x00, 33, 'C', 'C', 'C'}},
+		{TypeID: MessageVideo, Timestamp: 66, Payload: []byte{0x17, 0x01, 0x00, 0x00, 33, 'E', 'E', 'E', 'E'}},
+	} {
+		msg, err = mr.ReadMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if !reflect.DeepEqual(msg, expected) {
+			t.Errorf("Should be %#v, but got %#v", expected, msg)
+		}
+	}
+	if _, err = mr.ReadMessage(); err != io.EOF {
+		t.Errorf("Should be %s, but got %v", io.EOF, err)
+	}
+}
+
+func TestMP4ReaderSeek(t *testing.T) {
+	mr, err := NewMP4Reader(bytes.NewReader(testMP4()))
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	for _, x := range []struct {
+		ms, expected uint32
+		next         []uint32
+	}{
+		{50, 0, []uint32{0, 0, 23, 33, 66}},
+		{70, 66, []uint32{66}},
+		{0, 0, []uint32{0, 0, 23, 33, 66}},
+	} {
+		timestamp, err := mr.Seek(x.ms)
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if timestamp != x.expected {
+			t.Errorf("Should be %#v, but got %#v", x.expected, timestamp)
+		}
+		var next []uint32
+		for {
+			msg, err := mr.ReadMessage()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Should be nil, but got %s", err)
+			}
+			if msg.TypeID == MessageDataAMF0 || isVideoSequenceHeader(msg.Payload) || isAudioSequenceHeader(msg.Payload) {
+				continue
+			}
+			next = append(next, msg.Timestamp)
+		}
+		if !reflect.DeepEqual(next, x.next) {
+			t.Errorf("Should be %#v, but got %#v", x.next, next)
+		}
+	}
+}
+
+func TestFileSourceOpenMP4(t *testing.T) {
+	source := &FileSource{FS: fstest.MapFS{"vod/movie.mp4": &fstest.MapFile{Data: testMP4()}}}
+	r, err := source.Open("vod", "mp4:movie")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer r.Close()
+	if _, ok := r.(MediaSeeker); !ok {
+		t.Errorf("Should be a MediaSeeker, but got %T", r)
+	}
+	if msg, err := r.ReadMessage(); err != nil || !isMetadata(msg.Payload) {
+		t.Errorf("Should be onMetaData, but got %#v, %v", msg, err)
+	}
+}
+
+func TestMP4ReaderAudioSampleRate(t *testing.T) {
+	// AudioSpecificConfig of 96 kHz, which doesn't fit in the sample rate of mp4a.
+	data := bytes.Replace(testMP4(), []byte{0x05, 2, testASC[0], testASC[1]}, []byte{0x05, 2, 0x10, 0x10}, 1)
+	mr, err := NewMP4Reader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	msg, _ := mr.ReadMessage()
+	if metadata, err := ParseMetadata(msg.Payload); err != nil || metadata.AudioSampleRate != 96000 {
+		t.Errorf("Should be 96000 Hz, but got %#v, %v", metadata, err)
+	}
+}
+
+func TestNewMP4ReaderInvalid(t *testing.T) {
+	// A few hundred bytes of moov which has 2^24-1 samples of the fixed size.
+	avc1 := make([]byte, 78)
+	huge := testMP4Box("moov", testMP4Track("vide", 90000,
+		testMP4Box("avc1", avc1, testMP4Box("avcC", testAVCC)),
+		testMP4Box("stts", testMP4Table(1, 1<<24-1, 3000)),
+		testMP4Box("stsc", testMP4Table(1, 1, 1<<24-1, 1)),
+		testMP4Box("stsz", testMP4Table(1, 1<<24-1)), // sample size 1, count 2^24-1
+		testMP4Box("stco", testMP4Table(1, 0)),
+	))
+
+	for _, data := range [][]byte{
+		nil,
+		testMP4Box("ftyp", []byte("isom\x00\x00\x00\x00")),
+		testMP4Box("moov", testMP4Box("trak", testMP4Box("mdia"))),
+		testMP4()[:100],
+		huge,
+	} {
+		if _, err := NewMP4Reader(bytes.NewReader(data)); err == nil {
+			t.Errorf("Should be an error, but got nil for %x", data)
+		}
+	}
+}
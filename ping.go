@@ -0,0 +1,52 @@
+package rtmp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// keepalive sends PingRequest events every interval until done is closed.
+// It closes the connection if the peer misses more than maxMissed PingResponse events,
+// so that the goroutine blocked in reading from the dead peer returns.
+func (c *conn) keepalive(interval time.Duration, maxMissed int, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if missed := atomic.AddInt32(&c.missedPings, 1) - 1; int(missed) >= maxMissed {
+			c.log().Info("Close the connection: PingResponse events are missed", "missed", missed)
+			c.netconn.Close()
+			return
+		}
+		if err := c.writeMessages(userPingRequestMessage(c.now())); err != nil {
+			c.log().Error("Write PingRequest error", "error", err)
+			c.netconn.Close()
+			return
+		}
+	}
+}
+
+// pong measures the round-trip time from the timestamp echoed by the PingResponse event.
+func (c *conn) pong(timestamp uint32) {
+	rtt := time.Duration(c.now()-timestamp) * time.Millisecond
+	atomic.StoreInt64(&c.rtt, int64(rtt))
+	atomic.StoreInt32(&c.missedPings, 0)
+}
+
+// roundTripTime returns the round-trip time measured by the last PingResponse event.
+// It returns 0 if no PingResponse event was received.
+func (c *conn) roundTripTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+// now returns the milliseconds since the connection is established,
+// which is used as the timestamp of PingRequest events.
+func (c *conn) now() uint32 {
+	return uint32(time.Since(c.startTime) / time.Millisecond)
+}
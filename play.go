@@ -0,0 +1,192 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// playArguments are the arguments of the play command.
+type playArguments struct {
+	streamName string
+	// start is the start time in milliseconds. -2 means a live stream or a recorded stream,
+	// -1 means only a live stream and 0 or greater means a recorded stream from the time.
+	start float64
+	// duration is the duration of playback in milliseconds. -1 means until the end.
+	duration float64
+	// reset specifies whether to flush any previous playlist.
+	reset bool
+}
+
+// readPlayArguments reads the arguments of the play command after the transaction ID.
+//
+//	+-------------+----------+-----------------------------------------+
+//	| Field Name  |   Type   |               Description               |
+//	+-------------+----------+-----------------------------------------+
+//	| Command     |   Null   | Command information does not exist.     |
+//	| Object      |          | Set to null type.                       |
+//	+-------------+----------+-----------------------------------------+
+//	| Stream Name |  String  | Name of the stream to play.             |
+//	+-------------+----------+-----------------------------------------+
+//	| Start       |  Number  | An optional parameter. Default is -2.   |
+//	+-------------+----------+-----------------------------------------+
+//	| Duration    |  Number  | An optional parameter. Default is -1.   |
+//	+-------------+----------+-----------------------------------------+
+//	| Reset       |  Boolean | An optional parameter. Default is true. |
+//	+-------------+----------+-----------------------------------------+
+func readPlayArguments(buf *bytes.Buffer) (*playArguments, error) {
+	args := &playArguments{start: -2, duration: -1, reset: true}
+	if _, err := amf.ReadValue(buf); err != nil { // Returns null-type
+		return nil, err
+	}
+	streamName, err := amf.ReadString(buf)
+	if err != nil {
+		return nil, err
+	}
+	args.streamName = streamName
+
+	for i := 0; buf.Len() > 0; i++ {
+		v, err := amf.ReadValue(buf)
+		if err != nil {
+			return nil, err
+		}
+		switch x := v.(type) {
+		case float64:
+			if i == 0 {
+				args.start = x
+			} else if i == 1 {
+				args.duration = x
+			}
+		case bool:
+			args.reset = x
+		}
+	}
+	return args, nil
+}
+
+// play starts sending the live stream to the peer on the message stream.
+func (c *conn) play(streamID uint32, args *playArguments) error {
+	c.setStreamKey(args.streamName)
+	if err := c.server.handler().OnPlay(c.handle, args.streamName); err != nil {
+		c.log().Info("Reject play", "error", err)
+		return c.writeMessages(onStatusMessage(streamID, CommandLevelError, CodeNetStreamPlayFailed, err.Error()))
+	}
+
+	var sub *Subscriber
+	stream := c.server.streamHub().Lookup(c.handle.App(), args.streamName)
+	if stream != nil {
+		sub, _ = stream.Subscribe()
+	}
+	if sub == nil {
+		return c.playOnDemand(streamID, args)
+	}
+
+	if err := c.writeMessages(playStartMessages(streamID, args)...); err != nil {
+		sub.Close()
+		return err
+	}
+	c.playing = sub
+	c.setState(StatePlayingContent)
+	c.log().Info("Start playing")
+	go c.sendStream(streamID, sub)
+	return nil
+}
+
+// playOnDemand starts sending the recorded stream to the peer
+// if the stream is not live but Server.OnDemand has it.
+func (c *conn) playOnDemand(streamID uint32, args *playArguments) error {
+	if c.server.OnDemand == nil || args.start == -1 {
+		return c.streamNotFound(streamID, args)
+	}
+	r, err := c.server.OnDemand.Open(c.handle.App(), args.streamName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c.streamNotFound(streamID, args)
+	} else if err != nil {
+		c.log().Error("Open recorded stream error", "error", err)
+		return c.writeMessages(onStatusMessage(streamID, CommandLevelError, CodeNetStreamPlayFailed,
+			fmt.Sprintf("Failed to play %s.", args.streamName)))
+	}
+
+	if err = c.writeMessages(playStartMessages(streamID, args)...); err != nil {
+		r.Close()
+		return err
+	}
+	c.vod = newVODPlayer(c, streamID, args.streamName, r)
+	c.vod.startAt(args.start, args.duration)
+	c.setState(StatePlayingContent)
+	c.log().Info("Start playing the recorded stream")
+	go c.vod.run()
+	return nil
+}
+
+func (c *conn) streamNotFound(streamID uint32, args *playArguments) error {
+	c.log().Info("Stream not found")
+	return c.writeMessages(onStatusMessage(streamID, CommandLevelError, CodeNetStreamPlayStreamNotFound,
+		fmt.Sprintf("%s is not found.", args.streamName)))
+}
+
+// playStartMessages returns the messages sent to the peer before the media.
+func playStartMessages(streamID uint32, args *playArguments) []*Message {
+	msgs := []*Message{userStreamBeginMessage(streamID)}
+	if args.reset {
+		msgs = append(msgs, onStatusMessage(streamID, CommandLevelStatus, CodeNetStreamPlayReset,
+			fmt.Sprintf("Playing and resetting %s.", args.streamName)))
+	}
+	return append(msgs,
+		onStatusMessage(streamID, CommandLevelStatus, CodeNetStreamPlayStart,
+			fmt.Sprintf("Started playing %s.", args.streamName)),
+		rtmpSampleAccessMessage(streamID),
+	)
+}
+
+// sendStream writes the messages of the subscribed stream to the peer until the subscriber is closed.
+func (c *conn) sendStream(streamID uint32, sub *Subscriber) {
+	for msg := range sub.Messages() {
+		if err := c.writeMessages(playMessage(streamID, msg)); err != nil {
+			c.log().Error("Write media error", "error", err)
+			sub.Close()
+			c.netconn.Close()
+			return
+		}
+	}
+
+	switch sub.Err() {
+	case ErrStreamClosed:
+		c.log().Info("Stream is unpublished")
+		c.setState(StateSentCreateStreamResponse)
+		err := c.writeMessages(
+			userStreamEOFMessage(streamID),
+			onStatusMessage(streamID, CommandLevelStatus, CodeNetStreamPlayUnpublish,
+				fmt.Sprintf("%s is now unpublished.", sub.Stream().Name())),
+		)
+		if err != nil {
+			c.log().Error("Write StreamEOF error", "error", err)
+		}
+	case ErrSlowSubscriber:
+		c.log().Warn("Close the connection: the player is too slow")
+		c.netconn.Close()
+	}
+}
+
+// playMessage returns the copy of the message of the subscribed stream sent on the message stream.
+// Audio, video and data messages are sent on their own chunk stream,
+// so that the headers of the consecutive messages can be compressed.
+func playMessage(streamID uint32, msg *Message) *Message {
+	csid := uint32(5)
+	switch msg.TypeID {
+	case MessageAudio:
+		csid = 6
+	case MessageVideo:
+		csid = 7
+	}
+	return &Message{
+		ChunkStreamID: csid,
+		Timestamp:     msg.Timestamp,
+		TypeID:        msg.TypeID,
+		StreamID:      streamID,
+		Payload:       msg.Payload,
+	}
+}
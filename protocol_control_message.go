@@ -1,6 +1,9 @@
 package rtmp
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+)
 
 type PeerBandwidthLimitType int
 
@@ -10,53 +13,82 @@ const (
 	PeerBandwidthLimitTypeDynamic                        = 2
 )
 
-func generateProtocolControlMessageHeader(messageTypeID uint8, messageLength uint32) *ChunkHeader {
-	return &ChunkHeader{
-		BasicHeader: &BasicHeader{
-			FMT:           0,
-			ChunkStreamID: 2,
-		},
-		MessageHeader: &MessageHeader{
-			MessageLength: messageLength,
-			MessageTypeID: messageTypeID,
-		},
+// Protocol control messages are sent on the chunk stream ID 2 and the message stream ID 0.
+func newProtocolControlMessage(messageType MessageType, payload []byte) *Message {
+	return &Message{
+		ChunkStreamID: 2,
+		TypeID:        messageType,
+		StreamID:      0,
+		Payload:       payload,
 	}
 }
 
-func GenerateSetChunkSize(chunkSize uint32) ([]byte, error) {
-	ch := generateProtocolControlMessageHeader(1, 4)
-	x, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
-	}
-
+func setChunkSizeMessage(chunkSize uint32) *Message {
 	y := make([]byte, 4)
 	binary.BigEndian.PutUint32(y, chunkSize)
 	y[0] = y[0] & 0x7f
-	return append(x, y...), nil
+	return newProtocolControlMessage(MessageSetChunkSize, y)
 }
 
-func GenerateWindowAcknowledgementSizeChunk(size uint32) ([]byte, error) {
-	ch := generateProtocolControlMessageHeader(5, 4)
-	x, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
+func GenerateSetChunkSize(chunkSize uint32) ([]byte, error) {
+	return encodeMessage(setChunkSizeMessage(chunkSize))
+}
+
+// readChunkSize returns the chunk size of the payload of a Set Chunk Size message.
+// The chunk size 0 is an error because no payload could be sent in chunks.
+func readChunkSize(payload []byte) (uint32, error) {
+	//  0                   1                   2                   3
+	//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |0|                   chunk size (31 bits)                      |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	if len(payload) != 4 {
+		return 0, errors.New("the payload length of Set Chunk Size command should be 4")
+	}
+	chunkSize := binary.BigEndian.Uint32(payload) & 0x7fffffff
+	if chunkSize == 0 {
+		return 0, errors.New("chunk size should be greater than 0")
 	}
+	return chunkSize, nil
+}
 
+func abortMessage(chunkStreamID uint32) *Message {
+	y := make([]byte, 4)
+	binary.BigEndian.PutUint32(y, chunkStreamID)
+	return newProtocolControlMessage(MessageAbort, y)
+}
+
+func GenerateAbortChunk(chunkStreamID uint32) ([]byte, error) {
+	return encodeMessage(abortMessage(chunkStreamID))
+}
+
+func acknowledgementMessage(sequenceNumber uint32) *Message {
+	y := make([]byte, 4)
+	binary.BigEndian.PutUint32(y, sequenceNumber)
+	return newProtocolControlMessage(MessageAcknowledgement, y)
+}
+
+func GenerateAcknowledgementChunk(sequenceNumber uint32) ([]byte, error) {
+	return encodeMessage(acknowledgementMessage(sequenceNumber))
+}
+
+func windowAcknowledgementSizeMessage(size uint32) *Message {
 	y := make([]byte, 4)
 	binary.BigEndian.PutUint32(y, size)
-	return append(x, y...), nil
+	return newProtocolControlMessage(MessageAcknowledgementWindowSize, y)
 }
 
-func GenerateSetPeerBandwidthChunk(size uint32, limitType uint8) ([]byte, error) {
-	ch := generateProtocolControlMessageHeader(6, 5)
-	x, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
-	}
+func GenerateWindowAcknowledgementSizeChunk(size uint32) ([]byte, error) {
+	return encodeMessage(windowAcknowledgementSizeMessage(size))
+}
 
+func setPeerBandwidthMessage(size uint32, limitType uint8) *Message {
 	y := make([]byte, 5)
 	binary.BigEndian.PutUint32(y[:4], size)
 	y[4] = byte(limitType)
-	return append(x, y...), nil
+	return newProtocolControlMessage(MessageSetPeerBandwidth, y)
+}
+
+func GenerateSetPeerBandwidthChunk(size uint32, limitType uint8) ([]byte, error) {
+	return encodeMessage(setPeerBandwidthMessage(size, limitType))
 }
@@ -37,3 +37,25 @@ func TestGenerateSetChunkSize(t *testing.T) {
 		t.Errorf("should be %#v, but got %#v", expected, actual)
 	}
 }
+
+func TestGenerateAcknowledgementChunk(t *testing.T) {
+	expected := []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x26, 0x25, 0xa0}
+	actual, err := GenerateAcknowledgementChunk(2500000)
+	if err != nil {
+		t.Errorf("should be nil, but got %s", err)
+	}
+	if bytes.Compare(expected, actual) != 0 {
+		t.Errorf("should be %#v, but got %#v", expected, actual)
+	}
+}
+
+func TestGenerateAbortChunk(t *testing.T) {
+	expected := []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06}
+	actual, err := GenerateAbortChunk(6)
+	if err != nil {
+		t.Errorf("should be nil, but got %s", err)
+	}
+	if bytes.Compare(expected, actual) != 0 {
+		t.Errorf("should be %#v, but got %#v", expected, actual)
+	}
+}
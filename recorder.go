@@ -0,0 +1,133 @@
+package rtmp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Recorder records a published stream into an FLV file.
+//
+// The metadata and the sequence headers are written first, and then the media messages
+// with the timestamps rebased to zero. The recording finishes when the publisher closes the stream.
+type Recorder struct {
+	sub  *Subscriber
+	w    io.WriteCloser
+	bufw *bufio.Writer
+	fw   *FLVWriter
+
+	started bool
+	base    uint32 // the timestamp of the first media message except sequence headers.
+
+	done chan struct{}
+	err  error // set before done is closed.
+}
+
+// NewRecorder starts recording the stream into w. w is closed when the recording finishes.
+func NewRecorder(s *Stream, w io.WriteCloser) (*Recorder, error) {
+	sub, err := s.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	bufw := bufio.NewWriter(w)
+	r := &Recorder{
+		sub:  sub,
+		w:    w,
+		bufw: bufw,
+		fw:   NewFLVWriter(bufw),
+		done: make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	var err error
+	for msg := range r.sub.Messages() {
+		if err = r.writeMessage(msg); err != nil {
+			r.sub.Close()
+			break
+		}
+	}
+	if err == nil && r.sub.Err() != ErrStreamClosed {
+		err = r.sub.Err()
+	}
+	if ferr := r.bufw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := r.w.Close(); err == nil {
+		err = cerr
+	}
+	r.err = err
+}
+
+func (r *Recorder) writeMessage(msg *Message) error {
+	switch msg.TypeID {
+	case MessageDataAMF0:
+		if !isMetadata(msg.Payload) {
+			return nil
+		}
+		return r.fw.WriteTag(msg.TypeID, 0, msg.Payload)
+	case MessageAudio, MessageVideo:
+		if !r.started && (isVideoSequenceHeader(msg.Payload) || isAudioSequenceHeader(msg.Payload)) {
+			return r.fw.WriteTag(msg.TypeID, 0, msg.Payload)
+		}
+		if !r.started {
+			r.started = true
+			r.base = msg.Timestamp
+		}
+		timestamp := msg.Timestamp - r.base
+		if int32(timestamp) < 0 {
+			timestamp = 0
+		}
+		return r.fw.WriteTag(msg.TypeID, timestamp, msg.Payload)
+	}
+	return nil
+}
+
+// Wait waits for the recording to finish and returns the error occurred while recording.
+func (r *Recorder) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Close stops the recording and closes the file.
+func (r *Recorder) Close() error {
+	r.sub.Close()
+	return r.Wait()
+}
+
+// RecordDir returns the function for Server.Record which records the streams into
+// dir/<app>/<stream name>-<time>.flv. The app and the stream name are sanitized
+// so that the files can't be created outside dir.
+func RecordDir(dir string) func(app, streamKey string) (io.WriteCloser, error) {
+	return func(app, streamKey string) (io.WriteCloser, error) {
+		d := filepath.Join(dir, sanitizeFileName(app))
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%s-%s.flv", sanitizeFileName(streamKey), time.Now().Format("20060102-150405"))
+		return os.Create(filepath.Join(d, name))
+	}
+}
+
+// sanitizeFileName replaces the characters other than alphanumerics, '-', '_' and '.'
+// with '_', and a leading '.' too.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || name[0] == '.' {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,77 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type nopWriteCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *nopWriteCloser) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	hub := new(StreamHub)
+	s, _ := hub.Publish("live", "key")
+	w := new(nopWriteCloser)
+	r, err := NewRecorder(s, w)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+
+	s.WriteMessage(&Message{TypeID: MessageVideo, Timestamp: 0, Payload: []byte{0x17, 0x00}})
+	s.WriteMessage(&Message{TypeID: MessageVideo, Timestamp: 1000, Payload: []byte{0x17, 0x01}})
+	s.WriteMessage(&Message{TypeID: MessageAudio, Timestamp: 1020, Payload: []byte{0xaf, 0x01}})
+	s.WriteMessage(&Message{TypeID: MessageVideo, Timestamp: 1040, Payload: []byte{0x27, 0x01}})
+	s.Close()
+	if err = r.Wait(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if !w.closed {
+		t.Errorf("Should be closed")
+	}
+
+	// Read the type and the timestamp of each tag.
+	b := w.Bytes()[flvHeaderSize+4:]
+	var types []uint8
+	var timestamps []uint32
+	for len(b) > 0 {
+		types = append(types, b[0])
+		timestamps = append(timestamps, binary.BigEndian.Uint32(append([]byte{b[7]}, b[4:7]...)))
+		size := int(binary.BigEndian.Uint32(append([]byte{0}, b[1:4]...)))
+		b = b[flvTagHeaderSize+size+4:]
+	}
+	expectedTypes := []uint8{9, 9, 8, 9}
+	expectedTimestamps := []uint32{0, 0, 20, 40}
+	if bytes.Compare(expectedTypes, types) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expectedTypes, types)
+	}
+	for i := range expectedTimestamps {
+		if timestamps[i] != expectedTimestamps[i] {
+			t.Errorf("Should be %#v, but got %#v", expectedTimestamps, timestamps)
+			break
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, x := range []struct {
+		name     string
+		expected string
+	}{
+		{"key", "key"},
+		{"../../etc/passwd", "_.._.._etc_passwd"},
+		{"key?token=abc", "key_token_abc"},
+		{"", "_"},
+	} {
+		if actual := sanitizeFileName(x.name); actual != x.expected {
+			t.Errorf("Should be %#v, but got %#v", x.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package rtmp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	relayDialTimeout  = 10 * time.Second // to connect and start publishing.
+	relayWriteTimeout = 10 * time.Second
+	relayMinBackoff   = time.Second
+	relayMaxBackoff   = time.Minute
+	// relayStableTime is how long a session should stay up to reset the backoff, so that an upstream
+	// which accepts publish and drops the connection soon, e.g. for a bad key, isn't redialed every second.
+	relayStableTime = 30 * time.Second
+)
+
+var errUpstreamStopped = errors.New("upstream stopped the stream")
+
+// RelayMap maps an app or "<app>/<stream key>" to the URLs of the upstream servers.
+// Its Targets method can be used as Server.Relay:
+//
+//	srv.Relay = rtmp.RelayMap{
+//		"live":        {"rtmp://backup.example.com/live"},
+//		"live/studio": {"rtmp://a.rtmp.youtube.com/live2/xxxx-xxxx-xxxx"},
+//	}.Targets
+//
+// The URLs of the app are used for all streams of the app. The stream key is appended to them
+// if they don't have a stream name, e.g. "live/studio" is relayed to "rtmp://backup.example.com/live/studio".
+type RelayMap map[string][]string
+
+// Targets returns the URLs of the app followed by the URLs of the stream.
+func (m RelayMap) Targets(app, streamKey string) []string {
+	var urls []string
+	for _, u := range m[app] {
+		urls = append(urls, appendStreamKey(u, streamKey))
+	}
+	return append(urls, m[streamHubKey(app, streamKey)]...)
+}
+
+// appendStreamKey appends the stream key to the path of the URL if it doesn't have a stream name.
+// The query and the fragment are kept, e.g. "rtmp://example.com/live?token=x" becomes
+// "rtmp://example.com/live/studio?token=x".
+func appendStreamKey(rawurl, streamKey string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl // used as it is, and fails to dial.
+	}
+	if _, streamName := splitStreamPath(u.Path); streamName != "" {
+		return rawurl
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + streamKey
+	return u.String()
+}
+
+// redactStreamURL returns the URL without the stream name, which is often the secret stream key
+// of the upstream server, e.g. "rtmp://a.rtmp.youtube.com/live2".
+func redactStreamURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "invalid url"
+	}
+	app, _ := splitStreamPath(u.Path)
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/" + app}).String()
+}
+
+// RelayStatus is the status of the relay which pushes a stream to an upstream server.
+type RelayStatus struct {
+	URL           string
+	Connected     bool   // whether the relay is publishing the stream to the upstream server.
+	Bytes         uint64 // the bytes of the payloads sent to the upstream server.
+	Reconnects    int
+	LastError     error // the error which disconnected the relay last time. nil if it hasn't failed.
+	LastErrorTime time.Time
+}
+
+// relay pushes a stream to an upstream server while the stream is live, and reconnects
+// with exponential backoff if it fails. It receives the messages through its own Subscriber,
+// which is dropped if the upstream is too slow, so that it never blocks the publisher.
+type relay struct {
+	stream *Stream
+	url    string
+	logger *slog.Logger
+
+	minBackoff, maxBackoff time.Duration
+	stableTime             time.Duration
+
+	mu sync.Mutex // guards st.
+	st RelayStatus
+}
+
+// startRelay starts pushing the stream to the URL and registers the relay to the stream.
+func startRelay(s *Stream, u string, logger *slog.Logger) *relay {
+	r := &relay{
+		stream:     s,
+		url:        u,
+		logger:     logger,
+		minBackoff: relayMinBackoff,
+		maxBackoff: relayMaxBackoff,
+		stableTime: relayStableTime,
+		st:         RelayStatus{URL: u},
+	}
+	s.mu.Lock()
+	s.relays = append(s.relays, r)
+	s.mu.Unlock()
+	go r.run()
+	return r
+}
+
+func (r *relay) status() RelayStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.st
+}
+
+func (r *relay) run() {
+	backoff := r.minBackoff
+	for {
+		connectedAt, err := r.session()
+		r.mu.Lock()
+		r.st.Connected = false
+		r.mu.Unlock()
+		if err == ErrStreamClosed {
+			r.logger.Info("Finish relaying")
+			return
+		}
+
+		r.mu.Lock()
+		r.st.LastError = err
+		r.st.LastErrorTime = time.Now()
+		r.mu.Unlock()
+		if !connectedAt.IsZero() && time.Since(connectedAt) >= r.stableTime {
+			backoff = r.minBackoff
+		}
+		r.logger.Warn("Relay error", "error", err, "retry_after", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.stream.done:
+			timer.Stop()
+			r.logger.Info("Finish relaying")
+			return
+		}
+		if backoff *= 2; backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+		r.mu.Lock()
+		r.st.Reconnects++
+		r.mu.Unlock()
+	}
+}
+
+// session publishes the stream to the upstream server until an error occurs.
+// connectedAt is when it started publishing, or zero if it failed to start.
+// It returns ErrStreamClosed if the stream is closed.
+func (r *relay) session() (connectedAt time.Time, err error) {
+	// Subscribe first so that the cached metadata, sequence headers and GOP are sent after reconnecting.
+	sub, err := r.stream.Subscribe()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer sub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), relayDialTimeout)
+	c, err := DialContext(ctx, r.url)
+	if err == nil {
+		c.SetDeadline(time.Now().Add(relayDialTimeout))
+		if err = c.Publish(); err != nil {
+			c.Close()
+		}
+		c.SetDeadline(time.Time{})
+	}
+	cancel()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer c.Close()
+
+	connectedAt = time.Now()
+	r.mu.Lock()
+	r.st.Connected = true
+	r.mu.Unlock()
+	r.logger.Info("Start relaying")
+
+	// Read the messages sent by the upstream server to respond to PingRequest events
+	// and to detect the errors.
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				if err = sub.Err(); err == nil {
+					err = ErrStreamClosed
+				}
+				return connectedAt, err
+			}
+			if msg.TypeID == MessageDataAMF0 && isMetadata(msg.Payload) {
+				// The upstream server keeps the metadata for its players.
+				msg = setDataFrameMessage(msg)
+			}
+			c.SetWriteDeadline(time.Now().Add(relayWriteTimeout))
+			if err = c.WriteMessage(msg); err != nil {
+				return connectedAt, err
+			}
+			r.mu.Lock()
+			r.st.Bytes += uint64(len(msg.Payload))
+			r.mu.Unlock()
+		case err = <-readErr:
+			if err == io.EOF {
+				err = errUpstreamStopped
+			}
+			return connectedAt, err
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package rtmp
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRelayMapTargets(t *testing.T) {
+	m := RelayMap{
+		"live":        {"rtmp://backup.example.com/live", "rtmp://mirror.example.com/live/"},
+		"live/studio": {"rtmp://a.rtmp.youtube.com/live2/secret"},
+		"auth":        {"rtmp://auth.example.com/live?token=x"},
+	}
+	expected := []string{
+		"rtmp://backup.example.com/live/studio",
+		"rtmp://mirror.example.com/live/studio",
+		"rtmp://a.rtmp.youtube.com/live2/secret",
+	}
+	if actual := m.Targets("live", "studio"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+	expected = []string{"rtmp://auth.example.com/live/studio?token=x"}
+	if actual := m.Targets("auth", "studio"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+	if actual := m.Targets("other", "studio"); actual != nil {
+		t.Errorf("Should be nil, but got %#v", actual)
+	}
+}
+
+func TestRedactStreamURL(t *testing.T) {
+	expected := "rtmp://a.rtmp.youtube.com/live2"
+	if actual := redactStreamURL("rtmp://a.rtmp.youtube.com/live2/secret"); actual != expected {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+}
+
+// waitFor polls cond until it returns true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerRelay(t *testing.T) {
+	upstreamHub := new(StreamHub)
+	upstream := &Server{Streams: upstreamHub}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	upstreamAddr := ln.Addr().String()
+	go upstream.Serve(ln)
+
+	hub := new(StreamHub)
+	srv := &Server{
+		Streams: hub,
+		Relay:   RelayMap{"live/key": {"rtmp://" + upstreamAddr + "/live/copy"}}.Targets,
+	}
+	defer srv.Close()
+	publisher, err := Dial("rtmp://" + startTestServer(t, srv) + "/live/key")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer publisher.Close()
+	if err = publisher.Publish(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	sequenceHeader := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x1f, 0xff, 0xe0, 0x00}
+	publisher.WriteVideo(0, sequenceHeader)
+	publisher.WriteVideo(0, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0x01})
+
+	waitFor(t, "the relayed stream", func() bool {
+		s := upstreamHub.Lookup("live", "copy")
+		return s != nil && s.VideoConfig() != nil
+	})
+	stream := hub.Lookup("live", "key")
+	waitFor(t, "the relay status", func() bool {
+		st := stream.Relays()
+		return len(st) == 1 && st[0].Connected && st[0].Bytes == uint64(len(sequenceHeader)+6)
+	})
+
+	// The upstream failure doesn't affect the local stream.
+	upstream.Close()
+	waitFor(t, "the relay error", func() bool {
+		st := stream.Relays()[0]
+		return !st.Connected && st.LastError != nil
+	})
+	if err = publisher.WriteVideo(40, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0x02}); err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+	if s := hub.Lookup("live", "key"); s != stream {
+		t.Errorf("Should be published, but got %#v", s)
+	}
+
+	// The relay reconnects to the restarted upstream, and sends the cached sequence header again.
+	ln, err = net.Listen("tcp", upstreamAddr)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	upstreamHub = new(StreamHub)
+	upstream = &Server{Streams: upstreamHub}
+	defer upstream.Close()
+	go upstream.Serve(ln)
+	waitFor(t, "the reconnected stream", func() bool {
+		s := upstreamHub.Lookup("live", "copy")
+		return s != nil && s.VideoConfig() != nil
+	})
+	if st := stream.Relays()[0]; !st.Connected || st.Reconnects == 0 {
+		t.Errorf("Should be reconnected, but got %#v", st)
+	}
+
+	// The relay stops when the publisher stops.
+	publisher.Close()
+	waitFor(t, "the relay to stop", func() bool {
+		return upstreamHub.Lookup("live", "copy") == nil
+	})
+}
+
+// dropHandler accepts publish and drops the connection right after NetStream.Publish.Start.
+type dropHandler struct {
+	NopHandler
+}
+
+func (dropHandler) OnPublish(c *Conn, streamKey string) error {
+	time.AfterFunc(10*time.Millisecond, func() { c.Close() })
+	return nil
+}
+
+func TestRelayBackoff(t *testing.T) {
+	upstream := &Server{Handler: dropHandler{}}
+	defer upstream.Close()
+	stream, err := new(StreamHub).Publish("live", "key")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer stream.Close()
+
+	r := &relay{
+		stream:     stream,
+		url:        "rtmp://" + startTestServer(t, upstream) + "/live/copy",
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		minBackoff: 20 * time.Millisecond,
+		maxBackoff: time.Second,
+		stableTime: time.Second,
+	}
+	go r.run()
+
+	// The backoff doubles as 20, 40, 80, 160 and 320 ms since the sessions are too short to reset it.
+	// It would reconnect more than 20 times if the backoff was reset after each session.
+	time.Sleep(700 * time.Millisecond)
+	if st := r.status(); st.Reconnects < 3 || st.Reconnects > 6 || st.LastError == nil {
+		t.Errorf("Should reconnect 3 to 6 times, but got %#v", st)
+	}
+}
@@ -2,22 +2,104 @@ package rtmp
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"io"
 	"log"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrServerClosed is returned by the Server's Serve and ListenAndServe methods
+// after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("rtmp: Server closed")
+
 func ListenAndServe(addr string) error {
 	server := &Server{Addr: addr}
 	return server.ListenAndServe()
 }
 
+// DefaultMaxMissedPings is the number of PingResponse events which the peer can miss
+// if Server.MaxMissedPings is zero.
+const DefaultMaxMissedPings = 3
+
 type Server struct {
-	Addr     string      // If empty, use ":1935".
-	ErrorLog *log.Logger // If nil, logging goes to os.Stderr.
+	Addr    string  // If empty, use ":1935".
+	Handler Handler // Handler to invoke, NopHandler if nil.
+	// Logger is used to log the events of the server and connections.
+	// Connection events are logged with the remote_addr, conn_id, app and stream_key attributes.
+	// Lifecycle events are logged at the info level and protocol traces at the debug level.
+	// If nil, ErrorLog is used, or slog.Default() if ErrorLog is also nil.
+	Logger *slog.Logger
+	// ErrorLog is used to log the events if Logger is nil.
+	// All events including protocol traces are written to it with its prefix and flags.
+	//
+	// Deprecated: Use Logger.
+	ErrorLog *log.Logger
+
+	// HandshakeTimeout is the maximum duration to complete the RTMP handshake.
+	// If zero, there is no timeout.
+	HandshakeTimeout time.Duration
+	// ReadTimeout is the maximum duration for reading the rest of a chunk after its header.
+	// It is also used for waiting the next chunk if IdleTimeout is zero.
+	// If zero, there is no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration for writing messages to the peer.
+	// If zero, there is no timeout.
+	WriteTimeout time.Duration
+	// IdleTimeout is the maximum amount of time to wait for the next chunk.
+	// If zero, the value of ReadTimeout is used. It is not applied to the connections playing a stream,
+	// which may send nothing for a long time. Set PingInterval or WriteTimeout to close the dead players.
+	IdleTimeout time.Duration
+
+	// Streams is the registry of the live streams published to the server.
+	// If nil, a StreamHub owned by the server is used.
+	Streams *StreamHub
+
+	// OnDemand opens the recorded stream if the stream played by the play command is not live.
+	// If nil, only the live streams can be played. See FileSource.
+	OnDemand OnDemandSource
+
+	// Record returns the destination of the FLV recording of the stream published to the app.
+	// If it returns nil, the stream is not recorded. If Record is nil, no streams are recorded.
+	// See RecordDir.
+	Record func(app, streamKey string) (io.WriteCloser, error)
+
+	// Relay returns the URLs of the upstream servers, rtmp://host[:port]/app/stream,
+	// to which the stream published to the app is pushed while it is live.
+	// If it returns nil, the stream is not relayed. If Relay is nil, no streams are relayed.
+	// See RelayMap and Stream.Relays.
+	Relay func(app, streamKey string) []string
+
+	// TraceLevel is the initial trace level of the connections.
+	// It can be changed per connection by Conn.SetTraceLevel.
+	TraceLevel TraceLevel
+
+	// PingInterval is the interval to send PingRequest events to the peer.
+	// If zero, PingRequest events are not sent.
+	PingInterval time.Duration
+	// MaxMissedPings is the number of PingResponse events which the peer can miss in a row.
+	// The connection is closed if the peer misses more. If zero, DefaultMaxMissedPings is used.
+	MaxMissedPings int
+
+	inShutdown int32  // accessed atomically (non-zero means we're in Shutdown)
+	nextConnID uint64 // accessed atomically
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	activeConn map[*conn]struct{}
+	streams    *StreamHub // used if Streams is nil.
+
+	errorLogOnce sync.Once
+	errorLog     *slog.Logger // the adapter of ErrorLog.
 }
 
 func (srv *Server) ListenAndServe() error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
 	addr := srv.Addr
 	if addr == "" {
 		addr = ":1935"
@@ -30,12 +112,19 @@ func (srv *Server) ListenAndServe() error {
 }
 
 func (srv *Server) Serve(l net.Listener) error {
+	if !srv.trackListener(l, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
 	defer l.Close()
 	var tempDelay time.Duration // how long to sleep on accept failure
 
 	for {
 		rw, e := l.Accept()
 		if e != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
 			if ne, ok := e.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -45,7 +134,7 @@ func (srv *Server) Serve(l net.Listener) error {
 				if max := 1 * time.Second; tempDelay > max {
 					tempDelay = max
 				}
-				srv.logf("rtmp: Accept error: %v; retrying in %v", e, tempDelay)
+				srv.logger().Error("Accept error; retrying", "error", e, "delay", tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -53,24 +142,220 @@ func (srv *Server) Serve(l net.Listener) error {
 		}
 		tempDelay = 0
 		c := srv.newConn(rw)
-		go c.serve()
+		if !srv.trackConn(c, true) {
+			rw.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer srv.trackConn(c, false)
+			c.serve()
+		}()
+	}
+}
+
+// shutdownPollInterval is how often we poll for quiescence during Server.Shutdown.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Shutdown gracefully shuts down the server. It closes all listeners first,
+// then notifies NetConnection.Connect.AppShutdown to all connections and
+// waits for the peers to close them.
+// If the context expires before that, the remaining connections are closed
+// and the context's error is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&srv.inShutdown, 1)
+
+	srv.mu.Lock()
+	lnerr := srv.closeListenersLocked()
+	conns := make([]*conn, 0, len(srv.activeConn))
+	for c := range srv.activeConn {
+		conns = append(conns, c)
+	}
+	srv.mu.Unlock()
+
+	for _, c := range conns {
+		go func(c *conn) {
+			if err := c.notifyShutdown(); err != nil {
+				c.log().Error("Notify shutdown error", "error", err)
+			}
+		}(c)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if srv.numConns() == 0 {
+			return lnerr
+		}
+		select {
+		case <-ctx.Done():
+			srv.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections.
+func (srv *Server) Close() error {
+	atomic.StoreInt32(&srv.inShutdown, 1)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	err := srv.closeListenersLocked()
+	for c := range srv.activeConn {
+		c.netconn.Close()
+		delete(srv.activeConn, c)
+	}
+	return err
+}
+
+func (srv *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&srv.inShutdown) != 0
+}
+
+// trackListener adds or removes the listener. It returns false if the server is shutting down.
+func (srv *Server) trackListener(ln net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
 	}
+	if add {
+		if srv.shuttingDown() {
+			return false
+		}
+		srv.listeners[ln] = struct{}{}
+	} else {
+		delete(srv.listeners, ln)
+	}
+	return true
+}
+
+// trackConn adds or removes the connection. It returns false if the server is shutting down.
+func (srv *Server) trackConn(c *conn, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.activeConn == nil {
+		srv.activeConn = make(map[*conn]struct{})
+	}
+	if add {
+		if srv.shuttingDown() {
+			return false
+		}
+		srv.activeConn[c] = struct{}{}
+	} else {
+		delete(srv.activeConn, c)
+	}
+	return true
+}
+
+func (srv *Server) numConns() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.activeConn)
+}
+
+func (srv *Server) closeListenersLocked() error {
+	var err error
+	for ln := range srv.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(srv.listeners, ln)
+	}
+	return err
 }
 
 func (srv *Server) newConn(nc net.Conn) *conn {
-	return &conn{
-		netconn: nc,
-		server:  srv,
-		bufr:    nc,
-		bufw:    bufio.NewWriterSize(nc, 1024*64),
-		state:   StateUninitialized,
+	bytesReceived := &countingReader{r: bufio.NewReader(nc)}
+	bytesSent := &countingWriter{w: nc}
+	bufw := bufio.NewWriterSize(bytesSent, 1024*64)
+	c := &conn{
+		netconn:    nc,
+		traceLevel: int32(srv.TraceLevel),
+		startTime:  time.Now(),
+		server:     srv,
+		bufr:       bytesReceived,
+		bufw:       bufw,
+		state:      StateUninitialized,
+
+		chunkSize:    DefaultChunkSize,
+		chunkStreams: newChunkStreamContext(),
+		chunkWriter:  NewChunkWriter(bufw),
+
+		bytesReceived: bytesReceived,
+		bytesSent:     bytesSent,
 	}
+	c.handle = &Conn{c: c}
+	c.id = atomic.AddUint64(&srv.nextConnID, 1)
+	c.logger = c.newLogger()
+	return c
 }
 
-func (srv *Server) logf(format string, args ...interface{}) {
+func (srv *Server) streamHub() *StreamHub {
+	if srv.Streams != nil {
+		return srv.Streams
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.streams == nil {
+		srv.streams = new(StreamHub)
+	}
+	return srv.streams
+}
+
+func (srv *Server) handler() Handler {
+	if srv.Handler != nil {
+		return srv.Handler
+	}
+	return NopHandler{}
+}
+
+func (srv *Server) idleTimeout() time.Duration {
+	if srv.IdleTimeout != 0 {
+		return srv.IdleTimeout
+	}
+	return srv.ReadTimeout
+}
+
+func (srv *Server) maxMissedPings() int {
+	if srv.MaxMissedPings > 0 {
+		return srv.MaxMissedPings
+	}
+	return DefaultMaxMissedPings
+}
+
+func (srv *Server) logger() *slog.Logger {
+	if srv.Logger != nil {
+		return srv.Logger
+	}
 	if srv.ErrorLog != nil {
-		srv.ErrorLog.Printf(format, args...)
-	} else {
-		log.Printf(format, args...)
+		srv.errorLogOnce.Do(func() {
+			srv.errorLog = slog.New(slog.NewTextHandler(logWriter{srv.ErrorLog}, &slog.HandlerOptions{
+				Level: slog.LevelDebug,
+				// The time is written by ErrorLog as its flags say.
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))
+		})
+		return srv.errorLog
+	}
+	return slog.Default()
+}
+
+// logWriter writes each record of slog.TextHandler to the log.Logger,
+// so that the prefix and the flags of the logger are applied.
+type logWriter struct {
+	l *log.Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if err := w.l.Output(2, string(p)); err != nil {
+		return 0, err
 	}
+	return len(p), nil
 }
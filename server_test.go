@@ -0,0 +1,617 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+// testClient is a minimal RTMP client used to test the server.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	br     *bufio.Reader
+	ctx    *chunkStreamContext
+	chunkW *ChunkWriter
+	// chunkSize is the inbound chunk size.
+	chunkSize uint32
+}
+
+func startTestServer(t *testing.T, srv *Server) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	go srv.Serve(ln)
+	return ln.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string) *testClient {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	tc := &testClient{
+		t:         t,
+		conn:      nc,
+		br:        bufio.NewReader(nc),
+		ctx:       newChunkStreamContext(),
+		chunkW:    NewChunkWriter(nc),
+		chunkSize: DefaultChunkSize,
+	}
+
+	c1 := newChunkC1S1(0)
+	nc.Write(append(newChunkC0S0().Bytes(), c1.Bytes()...))
+	if _, err = readC0S0(tc.br); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	s1, err := readC1S1(tc.br)
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if _, err = readC2S2(tc.br); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	nc.Write(newChunkC2S2(s1).Bytes())
+	return tc
+}
+
+func (tc *testClient) writeCommand(streamID uint32, values ...interface{}) {
+	buf := new(bytes.Buffer)
+	for _, v := range values {
+		amf.WriteValue(buf, v)
+	}
+	if err := tc.chunkW.WriteMessage(newCommandMessage(streamID, buf.Bytes())); err != nil {
+		tc.t.Fatalf("Should be nil, but got %s", err)
+	}
+}
+
+func (tc *testClient) readMessage() (*Message, error) {
+	for {
+		ch, _, err := readChunkHeader(tc.br, tc.ctx)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := tc.ctx.get(ch.BasicHeader.ChunkStreamID).readChunkPayload(tc.br, ch, tc.chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue
+		}
+		if msg.TypeID == MessageSetChunkSize {
+			tc.chunkSize = binary.BigEndian.Uint32(msg.Payload) & 0x7fffffff
+		}
+		return msg, nil
+	}
+}
+
+// readCommand returns the values of the next command message.
+func (tc *testClient) readCommand() []interface{} {
+	for {
+		msg, err := tc.readMessage()
+		if err != nil {
+			tc.t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg.TypeID != MessageCommandAMF0 {
+			continue
+		}
+		var values []interface{}
+		buf := bytes.NewBuffer(msg.Payload)
+		for buf.Len() > 0 {
+			v, err := amf.ReadValue(buf)
+			if err != nil {
+				tc.t.Fatalf("Should be nil, but got %s", err)
+			}
+			values = append(values, v)
+		}
+		return values
+	}
+}
+
+func (tc *testClient) connect(app string) {
+	tc.writeCommand(0, "connect", 1, map[string]interface{}{
+		"app":   app,
+		"tcUrl": "rtmp://127.0.0.1/" + app,
+	})
+	values := tc.readCommand()
+	if values[0] != "_result" {
+		tc.t.Fatalf("Should be _result, but got %#v", values)
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	out := new(bytes.Buffer)
+	srv := &Server{ErrorLog: log.New(out, "rtmp: ", 0)}
+	logger := srv.logger()
+	if srv.logger() != logger {
+		t.Errorf("Should reuse the logger of ErrorLog")
+	}
+	logger.Info("Start publishing")
+	expected := "rtmp: level=INFO msg=\"Start publishing\"\n"
+	if actual := out.String(); actual != expected {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv := new(Server)
+	addr := startTestServer(t, srv)
+	tc := dialTestServer(t, addr)
+	defer tc.conn.Close()
+	tc.connect("live")
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Shutdown(context.Background())
+	}()
+
+	values := tc.readCommand()
+	info, ok := values[3].(amf.Object)
+	if values[0] != "onStatus" || !ok || info["code"] != string(CodeNetConnectAppShutdown) {
+		t.Errorf("Should be AppShutdown, but got %#v", values)
+	}
+	tc.conn.Close()
+	if err := <-errc; err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Errorf("Should not accept a connection after shutdown")
+	}
+	if err := srv.ListenAndServe(); err != ErrServerClosed {
+		t.Errorf("Should be %s, but got %v", ErrServerClosed, err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	srv := new(Server)
+	addr := startTestServer(t, srv)
+	tc := dialTestServer(t, addr)
+	defer tc.conn.Close()
+	tc.connect("live")
+
+	// The client doesn't close the connection after AppShutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Should be %s, but got %v", context.DeadlineExceeded, err)
+	}
+	tc.readCommand()
+	if _, err := tc.readMessage(); err == nil {
+		t.Errorf("Should be closed by the server")
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	srv := new(Server)
+	addr := startTestServer(t, srv)
+	tc := dialTestServer(t, addr)
+	defer tc.conn.Close()
+
+	if err := srv.Close(); err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+	if _, err := tc.readMessage(); err == nil {
+		t.Errorf("Should be closed by the server")
+	}
+}
+
+type testHandler struct {
+	NopHandler
+	published chan string
+	audio     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (h *testHandler) OnConnect(c *Conn, app, tcURL string, params map[string]interface{}) error {
+	if app != "live" {
+		return errors.New("unknown app")
+	}
+	return nil
+}
+
+func (h *testHandler) OnPublish(c *Conn, streamKey string) error {
+	if streamKey == "invalid" {
+		return errors.New("invalid stream key")
+	}
+	h.published <- c.App() + "/" + streamKey
+	return nil
+}
+
+func (h *testHandler) OnAudio(c *Conn, msg *Message) error {
+	h.audio <- msg.Payload
+	return nil
+}
+
+func (h *testHandler) OnClose(c *Conn) {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+func newTestHandler() *testHandler {
+	return &testHandler{
+		published: make(chan string, 1),
+		audio:     make(chan []byte, 1),
+		closed:    make(chan struct{}),
+	}
+}
+
+func TestServerHandlerRejectsConnect(t *testing.T) {
+	srv := &Server{Handler: newTestHandler()}
+	defer srv.Close()
+	tc := dialTestServer(t, startTestServer(t, srv))
+	defer tc.conn.Close()
+
+	tc.writeCommand(0, "connect", 1, map[string]interface{}{"app": "unknown"})
+	values := tc.readCommand()
+	info, ok := values[3].(amf.Object)
+	if values[0] != "_error" || !ok || info["code"] != string(CodeNetConnectRejected) {
+		t.Errorf("Should be Rejected, but got %#v", values)
+	}
+	if _, err := tc.readMessage(); err == nil {
+		t.Errorf("Should be closed by the server")
+	}
+}
+
+func TestServerHandlerPublish(t *testing.T) {
+	h := newTestHandler()
+	hub := new(StreamHub)
+	srv := &Server{Handler: h, Streams: hub}
+	defer srv.Close()
+	tc := dialTestServer(t, startTestServer(t, srv))
+	defer tc.conn.Close()
+	tc.connect("live")
+	tc.writeCommand(0, "createStream", 2, nil)
+	tc.readCommand()
+
+	tc.writeCommand(1, "publish", 3, nil, "invalid", "live")
+	values := tc.readCommand()
+	info, ok := values[3].(amf.Object)
+	if values[0] != "onStatus" || !ok || info["code"] != string(CodeNetStreamPublishBadName) {
+		t.Errorf("Should be BadName, but got %#v", values)
+	}
+
+	// StreamBegin and Publish.Start are sent on the message stream of the publish command.
+	tc.writeCommand(1, "publish", 4, nil, "key", "live")
+	msg, err := tc.readMessage()
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if msg.TypeID != MessageUserControl || binary.BigEndian.Uint32(msg.Payload[2:]) != 1 {
+		t.Errorf("Should be StreamBegin of the stream 1, but got %#v", msg)
+	}
+	if msg, err = tc.readMessage(); err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if msg.TypeID != MessageCommandAMF0 || msg.StreamID != 1 {
+		t.Errorf("Should be a command of the stream 1, but got %#v", msg)
+	}
+	buf := bytes.NewBuffer(msg.Payload)
+	for i := 0; i < 3; i++ {
+		amf.ReadValue(buf)
+	}
+	value, _ := amf.ReadValue(buf)
+	if info, ok = value.(amf.Object); !ok || info["code"] != string(CodeNetStreamPublishStart) {
+		t.Errorf("Should be Publish.Start, but got %#v", value)
+	}
+	if key := <-h.published; key != "live/key" {
+		t.Errorf("Should be %#v, but got %#v", "live/key", key)
+	}
+	sub, err := hub.Subscribe("live", "key")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+
+	expected := []byte{0xaf, 0x01, 0x21}
+	tc.chunkW.WriteMessage(&Message{ChunkStreamID: 4, TypeID: MessageAudio, StreamID: 1, Payload: expected})
+	if actual := <-h.audio; bytes.Compare(expected, actual) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expected, actual)
+	}
+	if actual := <-sub.Messages(); bytes.Compare(expected, actual.Payload) != 0 {
+		t.Errorf("Should be %#v, but got %#v", expected, actual.Payload)
+	}
+
+	tc.conn.Close()
+	select {
+	case <-h.closed:
+	case <-time.After(time.Second):
+		t.Errorf("OnClose should be called")
+	}
+	if s := hub.Lookup("live", "key"); s != nil {
+		t.Errorf("Should be unpublished, but got %#v", s)
+	}
+}
+
+// publish sends createStream and publish commands and waits for NetStream.Publish.Start.
+func (tc *testClient) publish(streamKey string) {
+	tc.writeCommand(0, "createStream", 2, nil)
+	tc.readCommand()
+	tc.writeCommand(1, "publish", 3, nil, streamKey, "live")
+	values := tc.readCommand()
+	if info, ok := values[3].(amf.Object); !ok || info["code"] != string(CodeNetStreamPublishStart) {
+		tc.t.Fatalf("Should be Publish.Start, but got %#v", values)
+	}
+}
+
+// readStatusCode returns the code of the next onStatus command.
+func (tc *testClient) readStatusCode() string {
+	values := tc.readCommand()
+	info, ok := values[3].(amf.Object)
+	if values[0] != "onStatus" || !ok {
+		tc.t.Fatalf("Should be onStatus, but got %#v", values)
+	}
+	code, _ := info["code"].(string)
+	return code
+}
+
+func TestServerPlay(t *testing.T) {
+	srv := new(Server)
+	defer srv.Close()
+	addr := startTestServer(t, srv)
+
+	publisher := dialTestServer(t, addr)
+	defer publisher.conn.Close()
+	publisher.connect("live")
+	publisher.publish("key")
+
+	player := dialTestServer(t, addr)
+	defer player.conn.Close()
+	player.connect("live")
+	player.writeCommand(0, "createStream", 2, nil)
+	player.readCommand()
+	player.writeCommand(1, "play", 3, nil, "key", -2.0)
+	for _, expected := range []string{string(CodeNetStreamPlayReset), string(CodeNetStreamPlayStart)} {
+		if code := player.readStatusCode(); code != expected {
+			t.Errorf("Should be %#v, but got %#v", expected, code)
+		}
+	}
+
+	expected := []byte{0x17, 0x01, 0x00, 0x00, 0x00}
+	publisher.chunkW.WriteMessage(&Message{ChunkStreamID: 4, Timestamp: 40, TypeID: MessageVideo, StreamID: 1, Payload: expected})
+	for {
+		msg, err := player.readMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg.TypeID != MessageVideo {
+			continue
+		}
+		if bytes.Compare(expected, msg.Payload) != 0 || msg.Timestamp != 40 || msg.StreamID != 1 {
+			t.Errorf("Should be the published video message, but got %#v", msg)
+		}
+		break
+	}
+
+	publisher.conn.Close()
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayUnpublish) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamPlayUnpublish, code)
+	}
+}
+
+func TestServerPlayStreamNotFound(t *testing.T) {
+	srv := new(Server)
+	defer srv.Close()
+	player := dialTestServer(t, startTestServer(t, srv))
+	defer player.conn.Close()
+	player.connect("live")
+	player.writeCommand(0, "createStream", 2, nil)
+	player.readCommand()
+
+	player.writeCommand(1, "play", 3, nil, "unknown")
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStreamNotFound) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamPlayStreamNotFound, code)
+	}
+}
+
+func TestServerPlayOnDemand(t *testing.T) {
+	flv := new(bytes.Buffer)
+	fw := NewFLVWriter(flv)
+	fw.WriteTag(MessageVideo, 0, []byte{0x17, 0x01})
+	fw.WriteTag(MessageVideo, 50, []byte{0x27, 0x01})
+	srv := &Server{OnDemand: &FileSource{FS: fstest.MapFS{"vod/movie.flv": &fstest.MapFile{Data: flv.Bytes()}}}}
+	defer srv.Close()
+
+	player := dialTestServer(t, startTestServer(t, srv))
+	defer player.conn.Close()
+	player.connect("vod")
+	player.writeCommand(0, "createStream", 2, nil)
+	player.readCommand()
+	player.writeCommand(1, "play", 3, nil, "movie")
+	for _, expected := range []string{string(CodeNetStreamPlayReset), string(CodeNetStreamPlayStart)} {
+		if code := player.readStatusCode(); code != expected {
+			t.Errorf("Should be %#v, but got %#v", expected, code)
+		}
+	}
+
+	start := time.Now()
+	var timestamps []uint32
+	for len(timestamps) < 2 {
+		msg, err := player.readMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg.TypeID == MessageVideo {
+			timestamps = append(timestamps, msg.Timestamp)
+		}
+	}
+	if timestamps[0] != 0 || timestamps[1] != 50 {
+		t.Errorf("Should be [0, 50], but got %#v", timestamps)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("Should be paced in real time, but got %s", d)
+	}
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStop) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamPlayStop, code)
+	}
+}
+
+func TestServerPlayOnDemandStartAndDuration(t *testing.T) {
+	flv := new(bytes.Buffer)
+	fw := NewFLVWriter(flv)
+	sequenceHeader := []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64, 0x00, 0x1f, 0xff, 0xe0, 0x00}
+	fw.WriteTag(MessageVideo, 0, sequenceHeader)
+	fw.WriteTag(MessageVideo, 0, []byte{0x17, 0x01})
+	fw.WriteTag(MessageVideo, 100, []byte{0x17, 0x01})
+	fw.WriteTag(MessageVideo, 150, []byte{0x27, 0x01})
+	fw.WriteTag(MessageVideo, 200, []byte{0x17, 0x01})
+	fw.WriteTag(MessageVideo, 300, []byte{0x27, 0x01})
+	srv := &Server{OnDemand: &FileSource{FS: fstest.MapFS{"vod/movie.flv": &fstest.MapFile{Data: flv.Bytes()}}}}
+	defer srv.Close()
+
+	player := dialTestServer(t, startTestServer(t, srv))
+	defer player.conn.Close()
+	player.connect("vod")
+	player.writeCommand(0, "createStream", 2, nil)
+	player.readCommand()
+	// Play 100 ms from 120 ms, which starts from the keyframe at 100 ms.
+	player.writeCommand(1, "play", 3, nil, "movie", 120, 100, false)
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStart) {
+		t.Fatalf("Should be %#v, but got %#v", CodeNetStreamPlayStart, code)
+	}
+	var timestamps []uint32
+	for {
+		msg, err := player.readMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg.TypeID == MessageVideo {
+			// The sequence header at the start of the file is sent first.
+			if len(timestamps) == 0 && !bytes.Equal(msg.Payload, sequenceHeader) {
+				t.Errorf("Should be the sequence header, but got %#v", msg.Payload)
+			}
+			timestamps = append(timestamps, msg.Timestamp)
+		}
+		if msg.TypeID == MessageUserControl {
+			if e, _ := ParseUserControlEvent(msg.Payload); e != nil && e.Type == UserControlStreamEOF {
+				break
+			}
+		}
+	}
+	if expected := []uint32{100, 100, 150, 200}; !reflect.DeepEqual(timestamps, expected) {
+		t.Errorf("Should be %#v, but got %#v", expected, timestamps)
+	}
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStop) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamPlayStop, code)
+	}
+}
+
+func TestServerPlayOnDemandMP4Start(t *testing.T) {
+	// The video samples are at 0, 1000 and 2000 ms, of which 0 and 2000 ms are sync samples.
+	data := bytes.Replace(testMP4(), testMP4Box("stts", testMP4Table(1, 3, 3000)), testMP4Box("stts", testMP4Table(1, 3, 90000)), 1)
+	srv := &Server{OnDemand: &FileSource{FS: fstest.MapFS{"vod/movie.mp4": &fstest.MapFile{Data: data}}}}
+	defer srv.Close()
+
+	player := dialTestServer(t, startTestServer(t, srv))
+	defer player.conn.Close()
+	player.connect("vod")
+	player.writeCommand(0, "createStream", 2, nil)
+	player.readCommand()
+	player.writeCommand(1, "play", 3, nil, "mp4:movie", 2000, -1, false)
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStart) {
+		t.Fatalf("Should be %#v, but got %#v", CodeNetStreamPlayStart, code)
+	}
+	started := time.Now()
+	for {
+		msg, err := player.readMessage()
+		if err != nil {
+			t.Fatalf("Should be nil, but got %s", err)
+		}
+		if msg.TypeID == MessageVideo && !isVideoSequenceHeader(msg.Payload) {
+			if msg.Timestamp != 2000 {
+				t.Errorf("Should be %#v, but got %#v", 2000, msg.Timestamp)
+			}
+			break
+		}
+	}
+	// The first frame is sent without waiting for the time from the sequence headers.
+	if d := time.Since(started); d > 500*time.Millisecond {
+		t.Errorf("Should be sent immediately, but got %s", d)
+	}
+}
+
+func TestServerSeekAndPause(t *testing.T) {
+	flv := new(bytes.Buffer)
+	fw := NewFLVWriter(flv)
+	fw.WriteTag(MessageVideo, 0, []byte{0x17, 0x01})
+	fw.WriteTag(MessageVideo, 1000, []byte{0x27, 0x01})
+	fw.WriteTag(MessageVideo, 2000, []byte{0x17, 0x01})
+	fw.WriteTag(MessageVideo, 2300, []byte{0x27, 0x01})
+	srv := &Server{OnDemand: &FileSource{FS: fstest.MapFS{"vod/movie.flv": &fstest.MapFile{Data: flv.Bytes()}}}}
+	defer srv.Close()
+
+	player := dialTestServer(t, startTestServer(t, srv))
+	defer player.conn.Close()
+	player.connect("vod")
+	player.writeCommand(0, "createStream", 2, nil)
+	player.readCommand()
+	player.writeCommand(1, "play", 3, nil, "movie", -2, -1, false)
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStart) {
+		t.Fatalf("Should be %#v, but got %#v", CodeNetStreamPlayStart, code)
+	}
+	nextVideo := func() uint32 {
+		for {
+			msg, err := player.readMessage()
+			if err != nil {
+				t.Fatalf("Should be nil, but got %s", err)
+			}
+			if msg.TypeID == MessageVideo {
+				return msg.Timestamp
+			}
+		}
+	}
+	if ts := nextVideo(); ts != 0 {
+		t.Errorf("Should be %#v, but got %#v", 0, ts)
+	}
+
+	// 2100 ms is between the keyframe at 2000 ms and the next frame.
+	player.writeCommand(1, "seek", 0, nil, 2100)
+	if code := player.readStatusCode(); code != string(CodeNetStreamSeekNotify) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamSeekNotify, code)
+	}
+	if ts := nextVideo(); ts != 2000 {
+		t.Errorf("Should be %#v, but got %#v", 2000, ts)
+	}
+
+	// The repeated pause and unpause commands are ignored.
+	player.writeCommand(1, "pause", 0, nil, true, 2000)
+	player.writeCommand(1, "pause", 0, nil, true, 2000)
+	if code := player.readStatusCode(); code != string(CodeNetStreamPauseNotify) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamPauseNotify, code)
+	}
+	time.Sleep(400 * time.Millisecond)
+	// It resumes from the position sent by the player, i.e. the keyframe at 2000 ms before 2200 ms.
+	player.writeCommand(1, "pause", 0, nil, false, 2200)
+	player.writeCommand(1, "pause", 0, nil, false, 2200)
+	unpaused := time.Now()
+	if code := player.readStatusCode(); code != string(CodeNetStreamUnpauseNotify) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamUnpauseNotify, code)
+	}
+	for _, expected := range []uint32{2000, 2300} {
+		if ts := nextVideo(); ts != expected {
+			t.Errorf("Should be %#v, but got %#v", expected, ts)
+		}
+	}
+	if d := time.Since(unpaused); d < 250*time.Millisecond {
+		t.Errorf("Should be paced from the resumed position, but got %s", d)
+	}
+	if code := player.readStatusCode(); code != string(CodeNetStreamPlayStop) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamPlayStop, code)
+	}
+
+	player.writeCommand(1, "seek", 0, nil, 0)
+	if code := player.readStatusCode(); code != string(CodeNetStreamSeekFailed) {
+		t.Errorf("Should be %#v, but got %#v", CodeNetStreamSeekFailed, code)
+	}
+}
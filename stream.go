@@ -0,0 +1,381 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrStreamAlreadyPublished is returned by StreamHub.Publish if the stream is already published.
+	ErrStreamAlreadyPublished = errors.New("rtmp: stream is already published")
+	// ErrStreamNotFound is returned by StreamHub.Subscribe if the stream is not published.
+	ErrStreamNotFound = errors.New("rtmp: stream not found")
+	// ErrSlowSubscriber is the error of the subscriber which is dropped
+	// because it doesn't receive the messages fast enough.
+	ErrSlowSubscriber = errors.New("rtmp: subscriber is too slow")
+	// ErrStreamClosed is the error of the subscriber after the publisher closed the stream.
+	ErrStreamClosed = errors.New("rtmp: stream is closed")
+)
+
+// DefaultSubscriberQueueSize is the number of messages queued for each subscriber
+// if StreamHub.QueueSize is zero.
+const DefaultSubscriberQueueSize = 1024
+
+// DefaultGOPCacheSize is the maximum bytes of the payloads in the GOP cache
+// if StreamHub.GOPCacheSize is zero.
+const DefaultGOPCacheSize = 8 * 1024 * 1024
+
+// A StreamHub is the registry of live streams keyed by the app and the stream name.
+// The zero value is ready to use.
+type StreamHub struct {
+	// QueueSize is the number of messages queued for each subscriber.
+	// A subscriber whose queue is full is dropped so that it can't block the publisher.
+	// If zero, DefaultSubscriberQueueSize is used.
+	QueueSize int
+	// GOPCacheSize is the maximum bytes of the payloads of the messages
+	// since the latest keyframe cached for new subscribers.
+	// If the cache exceeds it, the messages are not cached until the next keyframe.
+	// If zero, DefaultGOPCacheSize is used. If negative, the GOP cache is disabled.
+	GOPCacheSize int
+
+	mu      sync.Mutex
+	streams map[string]*Stream
+}
+
+func streamHubKey(app, name string) string {
+	return app + "/" + name
+}
+
+// Publish registers a new stream. It returns ErrStreamAlreadyPublished
+// if the stream of the same app and name is published.
+func (h *StreamHub) Publish(app, name string) (*Stream, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := streamHubKey(app, name)
+	if _, ok := h.streams[key]; ok {
+		return nil, ErrStreamAlreadyPublished
+	}
+	if h.streams == nil {
+		h.streams = make(map[string]*Stream)
+	}
+	s := &Stream{
+		hub:         h,
+		app:         app,
+		name:        name,
+		subscribers: make(map[*Subscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+	h.streams[key] = s
+	return s, nil
+}
+
+// Lookup returns the published stream, or nil if there is no such stream.
+func (h *StreamHub) Lookup(app, name string) *Stream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.streams[streamHubKey(app, name)]
+}
+
+// Subscribe subscribes to the published stream.
+// It returns ErrStreamNotFound if there is no such stream.
+func (h *StreamHub) Subscribe(app, name string) (*Subscriber, error) {
+	s := h.Lookup(app, name)
+	if s == nil {
+		return nil, ErrStreamNotFound
+	}
+	return s.Subscribe()
+}
+
+// Streams returns the published streams.
+func (h *StreamHub) Streams() []*Stream {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	streams := make([]*Stream, 0, len(h.streams))
+	for _, s := range h.streams {
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+func (h *StreamHub) remove(s *Stream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := streamHubKey(s.app, s.name)
+	if h.streams[key] == s {
+		delete(h.streams, key)
+	}
+}
+
+func (h *StreamHub) queueSize() int {
+	if h.QueueSize > 0 {
+		return h.QueueSize
+	}
+	return DefaultSubscriberQueueSize
+}
+
+func (h *StreamHub) gopCacheSize() int {
+	if h.GOPCacheSize != 0 {
+		return h.GOPCacheSize
+	}
+	return DefaultGOPCacheSize
+}
+
+// A Stream is a live stream published to a StreamHub.
+// The messages written by the publisher are fanned out to all subscribers.
+//
+// The stream caches the latest metadata, the sequence headers and the messages
+// since the latest video keyframe, and sends them to new subscribers first
+// so that players can start playback without waiting for the next keyframe.
+type Stream struct {
+	hub  *StreamHub
+	app  string
+	name string
+
+	mu          sync.Mutex // guards the following fields.
+	subscribers map[*Subscriber]struct{}
+	closed      bool
+	done        chan struct{} // closed when the stream is closed.
+	relays      []*relay
+
+	metadata            *Message
+	streamMetadata      *StreamMetadata // nil if the metadata is not received or invalid.
+	videoSequenceHeader *Message
+	audioSequenceHeader *Message
+	videoConfig         *VideoConfig // nil if the video sequence header is not received or invalid.
+	audioConfig         *AudioConfig // nil if the audio sequence header is not received or invalid.
+	gop                 []*Message   // nil if the messages are not cached until the next keyframe.
+	gopSize             int          // the bytes of the payloads in gop.
+}
+
+// App returns the application name of the stream.
+func (s *Stream) App() string {
+	return s.app
+}
+
+// Name returns the name of the stream.
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// Subscribe adds a new subscriber to the stream.
+// It returns ErrStreamClosed if the stream is closed.
+func (s *Stream) Subscribe() (*Subscriber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrStreamClosed
+	}
+	cached := s.cachedMessagesLocked()
+	sub := &Subscriber{
+		stream: s,
+		queue:  make(chan *Message, len(cached)+s.hub.queueSize()),
+	}
+	for _, msg := range cached {
+		sub.queue <- msg
+	}
+	s.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Metadata returns the latest metadata sent by the publisher.
+// It returns nil if the publisher doesn't send valid metadata.
+func (s *Stream) Metadata() *StreamMetadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streamMetadata
+}
+
+// VideoConfig returns the configuration parsed from the latest video sequence header.
+// It returns nil if the publisher doesn't send a valid AVC sequence header.
+func (s *Stream) VideoConfig() *VideoConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.videoConfig
+}
+
+// AudioConfig returns the configuration parsed from the latest audio sequence header.
+// It returns nil if the publisher doesn't send a valid AAC sequence header.
+func (s *Stream) AudioConfig() *AudioConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.audioConfig
+}
+
+// NumSubscribers returns the number of subscribers of the stream.
+func (s *Stream) NumSubscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers)
+}
+
+// Relays returns the status of the relays which push the stream to the upstream servers.
+func (s *Stream) Relays() []RelayStatus {
+	s.mu.Lock()
+	relays := s.relays
+	s.mu.Unlock()
+	statuses := make([]RelayStatus, len(relays))
+	for i, r := range relays {
+		statuses[i] = r.status()
+	}
+	return statuses
+}
+
+// WriteMessage sends the message to all subscribers without blocking.
+// The message is shared by the subscribers, so it must not be modified after that.
+//
+// @setDataFrame is removed from the metadata, so the subscribers receive onMetaData.
+func (s *Stream) WriteMessage(msg *Message) {
+	s.writeMessage(msg)
+}
+
+// streamUpdate is the change of the stream caused by a message written by the publisher.
+type streamUpdate int
+
+const (
+	streamUpdateNone streamUpdate = iota
+	// streamUpdateMetadata means the message is the metadata.
+	streamUpdateMetadata
+	// streamUpdateSequenceHeader means the message is the first sequence header or the same as the previous one.
+	streamUpdateSequenceHeader
+	// streamUpdateSequenceHeaderChanged means the message is the sequence header which differs from the previous one.
+	streamUpdateSequenceHeaderChanged
+)
+
+// writeMessage is like WriteMessage but reports how the stream is updated by the message.
+func (s *Stream) writeMessage(msg *Message) streamUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.TypeID == MessageDataAMF0 && isMetadata(msg.Payload) {
+		msg = onMetaDataMessage(msg)
+	}
+	update := s.cacheLocked(msg)
+	for sub := range s.subscribers {
+		select {
+		case sub.queue <- msg:
+		default:
+			s.removeLocked(sub, ErrSlowSubscriber)
+		}
+	}
+	return update
+}
+
+// cacheLocked caches the message for new subscribers.
+func (s *Stream) cacheLocked(msg *Message) streamUpdate {
+	switch {
+	case msg.TypeID == MessageDataAMF0 && isMetadata(msg.Payload):
+		s.metadata = msg
+		s.streamMetadata, _ = ParseMetadata(msg.Payload)
+		return streamUpdateMetadata
+	case msg.TypeID == MessageVideo && isVideoSequenceHeader(msg.Payload):
+		prev := s.videoSequenceHeader
+		s.videoSequenceHeader = msg
+		s.videoConfig, _ = ParseVideoSequenceHeader(msg.Payload)
+		return s.sequenceHeaderUpdateLocked(prev, msg)
+	case msg.TypeID == MessageAudio && isAudioSequenceHeader(msg.Payload):
+		prev := s.audioSequenceHeader
+		s.audioSequenceHeader = msg
+		s.audioConfig, _ = ParseAudioSequenceHeader(msg.Payload)
+		return s.sequenceHeaderUpdateLocked(prev, msg)
+	case msg.TypeID == MessageVideo && isVideoKeyframe(msg.Payload):
+		s.gop = nil
+		s.gopSize = 0
+		if max := s.hub.gopCacheSize(); max > 0 && len(msg.Payload) <= max {
+			s.gop = []*Message{msg}
+			s.gopSize = len(msg.Payload)
+		}
+	case msg.TypeID == MessageVideo || msg.TypeID == MessageAudio:
+		if s.gop == nil {
+			return streamUpdateNone
+		}
+		if s.gopSize+len(msg.Payload) > s.hub.gopCacheSize() {
+			// The GOP is too large. Wait for the next keyframe.
+			s.gop = nil
+			s.gopSize = 0
+			return streamUpdateNone
+		}
+		s.gop = append(s.gop, msg)
+		s.gopSize += len(msg.Payload)
+	}
+	return streamUpdateNone
+}
+
+// sequenceHeaderUpdateLocked returns whether the sequence header is changed from prev.
+// The cached GOP is dropped if it is changed, because the frames can't be decoded with the new one.
+func (s *Stream) sequenceHeaderUpdateLocked(prev, msg *Message) streamUpdate {
+	if prev != nil && !bytes.Equal(prev.Payload, msg.Payload) {
+		s.gop = nil
+		s.gopSize = 0
+		return streamUpdateSequenceHeaderChanged
+	}
+	return streamUpdateSequenceHeader
+}
+
+// cachedMessagesLocked returns the messages sent to a new subscriber first.
+func (s *Stream) cachedMessagesLocked() []*Message {
+	var msgs []*Message
+	for _, msg := range []*Message{s.metadata, s.videoSequenceHeader, s.audioSequenceHeader} {
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return append(msgs, s.gop...)
+}
+
+// Close unregisters the stream from the hub and closes all subscribers.
+func (s *Stream) Close() {
+	s.hub.remove(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	for sub := range s.subscribers {
+		s.removeLocked(sub, ErrStreamClosed)
+	}
+}
+
+func (s *Stream) removeLocked(sub *Subscriber, err error) {
+	if _, ok := s.subscribers[sub]; !ok {
+		return
+	}
+	delete(s.subscribers, sub)
+	sub.err = err
+	close(sub.queue)
+}
+
+// A Subscriber receives the messages of a Stream through its own bounded queue.
+type Subscriber struct {
+	stream *Stream
+	queue  chan *Message
+	err    error // set before queue is closed.
+}
+
+// Stream returns the subscribed stream.
+func (sub *Subscriber) Stream() *Stream {
+	return sub.stream
+}
+
+// Messages returns the channel of the messages written to the stream.
+// The channel is closed when the subscriber is closed or dropped. Err tells the reason.
+func (sub *Subscriber) Messages() <-chan *Message {
+	return sub.queue
+}
+
+// Err returns the reason why the channel returned by Messages is closed.
+// It returns nil if the channel is still open or the subscriber is closed by Close.
+func (sub *Subscriber) Err() error {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+	return sub.err
+}
+
+// Close unsubscribes from the stream.
+func (sub *Subscriber) Close() {
+	sub.stream.mu.Lock()
+	defer sub.stream.mu.Unlock()
+	sub.stream.removeLocked(sub, nil)
+}
@@ -0,0 +1,215 @@
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zhangpeihao/goamf"
+)
+
+func TestStreamHubPublish(t *testing.T) {
+	hub := new(StreamHub)
+	s, err := hub.Publish("live", "key")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	if _, err = hub.Publish("live", "key"); err != ErrStreamAlreadyPublished {
+		t.Errorf("Should be %s, but got %v", ErrStreamAlreadyPublished, err)
+	}
+	if _, err = hub.Publish("other", "key"); err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+	if actual := hub.Lookup("live", "key"); actual != s {
+		t.Errorf("Should be %#v, but got %#v", s, actual)
+	}
+
+	s.Close()
+	if actual := hub.Lookup("live", "key"); actual != nil {
+		t.Errorf("Should be nil, but got %#v", actual)
+	}
+	if _, err = hub.Subscribe("live", "key"); err != ErrStreamNotFound {
+		t.Errorf("Should be %s, but got %v", ErrStreamNotFound, err)
+	}
+}
+
+func TestStreamFanOut(t *testing.T) {
+	hub := new(StreamHub)
+	s, _ := hub.Publish("live", "key")
+	sub1, _ := hub.Subscribe("live", "key")
+	sub2, _ := hub.Subscribe("live", "key")
+
+	msg := &Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x01}}
+	s.WriteMessage(msg)
+	for _, sub := range []*Subscriber{sub1, sub2} {
+		if actual := <-sub.Messages(); actual != msg {
+			t.Errorf("Should be %#v, but got %#v", msg, actual)
+		}
+	}
+
+	sub1.Close()
+	if n := s.NumSubscribers(); n != 1 {
+		t.Errorf("Should be 1, but got %d", n)
+	}
+	if _, ok := <-sub1.Messages(); ok {
+		t.Errorf("Should be closed")
+	}
+
+	s.Close()
+	if _, ok := <-sub2.Messages(); ok {
+		t.Errorf("Should be closed")
+	}
+	if err := sub2.Err(); err != ErrStreamClosed {
+		t.Errorf("Should be %s, but got %v", ErrStreamClosed, err)
+	}
+	if _, err := s.Subscribe(); err != ErrStreamClosed {
+		t.Errorf("Should be %s, but got %v", ErrStreamClosed, err)
+	}
+}
+
+func TestStreamDropsSlowSubscriber(t *testing.T) {
+	hub := &StreamHub{QueueSize: 2}
+	s, _ := hub.Publish("live", "key")
+	slow, _ := s.Subscribe()
+	fast, _ := s.Subscribe()
+
+	for i := 0; i < 3; i++ {
+		s.WriteMessage(&Message{TypeID: MessageAudio})
+		<-fast.Messages()
+	}
+	if err := slow.Err(); err != ErrSlowSubscriber {
+		t.Errorf("Should be %s, but got %v", ErrSlowSubscriber, err)
+	}
+	if err := fast.Err(); err != nil {
+		t.Errorf("Should be nil, but got %s", err)
+	}
+	// The queued messages are still readable after the subscriber is dropped.
+	n := 0
+	for range slow.Messages() {
+		n++
+	}
+	if n != 2 {
+		t.Errorf("Should be 2, but got %d", n)
+	}
+}
+
+func TestStreamGOPCache(t *testing.T) {
+	metadata := &Message{TypeID: MessageDataAMF0, Payload: []byte{0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a'}}
+	videoSequenceHeader := &Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x00}}
+	audioSequenceHeader := &Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x00}}
+	keyframe1 := &Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x01, 0x00}}
+	keyframe2 := &Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x01, 0x01}}
+	interframe := &Message{TypeID: MessageVideo, Payload: []byte{0x27, 0x01, 0x00}}
+	audio := &Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x01, 0x00}}
+
+	hub := new(StreamHub)
+	s, _ := hub.Publish("live", "key")
+	for _, msg := range []*Message{metadata, videoSequenceHeader, audioSequenceHeader, keyframe1, interframe, keyframe2, interframe, audio} {
+		s.WriteMessage(msg)
+	}
+
+	sub, _ := s.Subscribe()
+	expected := []*Message{metadata, videoSequenceHeader, audioSequenceHeader, keyframe2, interframe, audio}
+	for i := range expected {
+		if actual := <-sub.Messages(); actual != expected[i] {
+			t.Errorf("Should be %#v, but got %#v", expected[i], actual)
+		}
+	}
+	select {
+	case msg := <-sub.Messages():
+		t.Errorf("Should be empty, but got %#v", msg)
+	default:
+	}
+}
+
+func TestStreamGOPCacheSize(t *testing.T) {
+	hub := &StreamHub{GOPCacheSize: 5}
+	s, _ := hub.Publish("live", "key")
+	s.WriteMessage(&Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x01, 0x00}})
+	s.WriteMessage(&Message{TypeID: MessageVideo, Payload: []byte{0x27, 0x01, 0x00}})
+
+	// The GOP exceeds 5 bytes, so it is not cached.
+	sub, _ := s.Subscribe()
+	select {
+	case msg := <-sub.Messages():
+		t.Errorf("Should be empty, but got %#v", msg)
+	default:
+	}
+}
+
+func TestStreamSequenceHeaderChange(t *testing.T) {
+	hub := new(StreamHub)
+	s, _ := hub.Publish("live", "key")
+
+	if update := s.writeMessage(&Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x00, 0x12, 0x10}}); update != streamUpdateSequenceHeader {
+		t.Errorf("Should be %d, but got %d", streamUpdateSequenceHeader, update)
+	}
+	if config := s.AudioConfig(); config == nil || config.SampleRate != 44100 {
+		t.Errorf("Should be 44100 Hz, but got %#v", config)
+	}
+	if update := s.writeMessage(&Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x00, 0x12, 0x10}}); update != streamUpdateSequenceHeader {
+		t.Errorf("Should be %d, but got %d", streamUpdateSequenceHeader, update)
+	}
+	// AAC LC, 48000 Hz, 2 channels
+	if update := s.writeMessage(&Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x00, 0x11, 0x90}}); update != streamUpdateSequenceHeaderChanged {
+		t.Errorf("Should be %d, but got %d", streamUpdateSequenceHeaderChanged, update)
+	}
+	if config := s.AudioConfig(); config == nil || config.SampleRate != 48000 {
+		t.Errorf("Should be 48000 Hz, but got %#v", config)
+	}
+	if update := s.writeMessage(&Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x01, 0x00}}); update != streamUpdateNone {
+		t.Errorf("Should be %d, but got %d", streamUpdateNone, update)
+	}
+}
+
+func TestStreamSequenceHeaderChangeDropsGOP(t *testing.T) {
+	keyframe := &Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x01, 0x00}}
+	interframe := &Message{TypeID: MessageVideo, Payload: []byte{0x27, 0x01, 0x00}}
+	audio := &Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x01, 0x00}}
+	for _, x := range []struct {
+		prev, changed *Message
+	}{
+		{&Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x00, 0x01}}, &Message{TypeID: MessageVideo, Payload: []byte{0x17, 0x00, 0x02}}},
+		{&Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x00, 0x12, 0x10}}, &Message{TypeID: MessageAudio, Payload: []byte{0xaf, 0x00, 0x11, 0x90}}},
+	} {
+		hub := new(StreamHub)
+		s, _ := hub.Publish("live", "key")
+		for _, msg := range []*Message{x.prev, keyframe, interframe, audio, x.changed} {
+			s.WriteMessage(msg)
+		}
+
+		// The frames encoded with the previous configuration are not sent.
+		sub, _ := s.Subscribe()
+		if actual := <-sub.Messages(); actual != x.changed {
+			t.Errorf("Should be %#v, but got %#v", x.changed, actual)
+		}
+		select {
+		case msg := <-sub.Messages():
+			t.Errorf("Should be empty, but got %#v", msg)
+		default:
+		}
+	}
+}
+
+func TestStreamMetadata(t *testing.T) {
+	buf := new(bytes.Buffer)
+	amf.WriteValue(buf, "@setDataFrame")
+	amf.WriteValue(buf, "onMetaData")
+	amf.WriteValue(buf, map[string]interface{}{"width": 1280.0, "height": 720.0, "encoder": "obs-output module"})
+
+	hub := new(StreamHub)
+	s, _ := hub.Publish("live", "key")
+	sub, _ := s.Subscribe()
+	if update := s.writeMessage(&Message{TypeID: MessageDataAMF0, Payload: buf.Bytes()}); update != streamUpdateMetadata {
+		t.Errorf("Should be %d, but got %d", streamUpdateMetadata, update)
+	}
+	if m := s.Metadata(); m == nil || m.Width != 1280 || m.Height != 720 || m.Encoder != "obs-output module" {
+		t.Errorf("Should be the metadata, but got %#v", m)
+	}
+
+	// Subscribers receive onMetaData without @setDataFrame.
+	msg := <-sub.Messages()
+	name, err := amf.ReadString(bytes.NewBuffer(msg.Payload))
+	if err != nil || name != "onMetaData" {
+		t.Errorf("Should be onMetaData, but got %#v, %v", name, err)
+	}
+}
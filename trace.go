@@ -0,0 +1,52 @@
+package rtmp
+
+import "sync/atomic"
+
+// TraceLevel is the verbosity of the protocol traces of a connection.
+// Traces are logged at the debug level of Server.Logger.
+type TraceLevel int32
+
+const (
+	// TraceOff disables the protocol traces.
+	TraceOff TraceLevel = iota
+	// TraceMessages traces the messages received from and sent to the peer.
+	TraceMessages
+	// TraceChunks additionally traces the handshake and every chunk header.
+	TraceChunks
+)
+
+// SetTraceLevel changes the trace level of the connection.
+func (c *Conn) SetTraceLevel(level TraceLevel) {
+	atomic.StoreInt32(&c.c.traceLevel, int32(level))
+}
+
+// TraceLevel returns the trace level of the connection.
+func (c *Conn) TraceLevel() TraceLevel {
+	return TraceLevel(atomic.LoadInt32(&c.c.traceLevel))
+}
+
+func (c *conn) tracing(level TraceLevel) bool {
+	return TraceLevel(atomic.LoadInt32(&c.traceLevel)) >= level
+}
+
+// trace logs the protocol trace if the trace level of the connection is level or more verbose.
+func (c *conn) trace(level TraceLevel, msg string, args ...interface{}) {
+	if !c.tracing(level) {
+		return
+	}
+	c.log().Debug(msg, args...)
+}
+
+// traceMessage logs the header of the message at TraceMessages level.
+func (c *conn) traceMessage(text string, msg *Message) {
+	if !c.tracing(TraceMessages) {
+		return
+	}
+	c.log().Debug(text,
+		"csid", msg.ChunkStreamID,
+		"timestamp", msg.Timestamp,
+		"length", len(msg.Payload),
+		"type", msg.TypeID,
+		"stream_id", msg.StreamID,
+	)
+}
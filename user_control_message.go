@@ -1,34 +1,166 @@
 package rtmp
 
-import "encoding/binary"
-
-func generateUserControlMessageHeader(messageLength uint32) *ChunkHeader {
-	return &ChunkHeader{
-		BasicHeader: &BasicHeader{
-			FMT:           0,
-			ChunkStreamID: 2,
-		},
-		MessageHeader: &MessageHeader{
-			MessageLength: messageLength,
-			MessageTypeID: 4,
-		},
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errInvalidUserControlEvent = errors.New("invalid user control event")
+
+type UserControlEventType uint16
+
+const (
+	// UserControlStreamBegin notifies that a stream has become functional and can be used for communication.
+	UserControlStreamBegin UserControlEventType = 0
+	// UserControlStreamEOF notifies that the playback of data is over as requested on this stream.
+	UserControlStreamEOF UserControlEventType = 1
+	// UserControlStreamDry notifies that there is no more data on the stream.
+	UserControlStreamDry UserControlEventType = 2
+	// UserControlSetBufferLength informs the server of the buffer size (in milliseconds) used to buffer any data coming over a stream.
+	UserControlSetBufferLength UserControlEventType = 3
+	// UserControlStreamIsRecorded notifies that the stream is a recorded stream.
+	UserControlStreamIsRecorded UserControlEventType = 4
+	// UserControlPingRequest is used to test whether the peer is reachable.
+	UserControlPingRequest UserControlEventType = 6
+	// UserControlPingResponse is sent in response to the ping request with the received timestamp.
+	UserControlPingResponse UserControlEventType = 7
+)
+
+// The payload of User Control Message consists of the event type and the event data:
+//
+//  0                   1                   2                   3
+//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |     Event Type (16 bits)      |       Event Data
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+
+// UserControlEvent is an event sent in a User Control Message.
+type UserControlEvent struct {
+	Type         UserControlEventType
+	StreamID     uint32 // Used except PingRequest and PingResponse.
+	BufferLength uint32 // Used by SetBufferLength.
+	Timestamp    uint32 // Used by PingRequest and PingResponse.
+}
+
+// ParseUserControlEvent parses the payload of a User Control Message.
+func ParseUserControlEvent(payload []byte) (*UserControlEvent, error) {
+	if len(payload) < 2 {
+		return nil, errInvalidUserControlEvent
+	}
+	e := &UserControlEvent{
+		Type: UserControlEventType(binary.BigEndian.Uint16(payload[:2])),
 	}
+	data := payload[2:]
+
+	switch e.Type {
+	case UserControlStreamBegin, UserControlStreamEOF, UserControlStreamDry, UserControlStreamIsRecorded:
+		if len(data) < 4 {
+			return nil, errInvalidUserControlEvent
+		}
+		e.StreamID = binary.BigEndian.Uint32(data)
+	case UserControlSetBufferLength:
+		if len(data) < 8 {
+			return nil, errInvalidUserControlEvent
+		}
+		e.StreamID = binary.BigEndian.Uint32(data[:4])
+		e.BufferLength = binary.BigEndian.Uint32(data[4:8])
+	case UserControlPingRequest, UserControlPingResponse:
+		if len(data) < 4 {
+			return nil, errInvalidUserControlEvent
+		}
+		e.Timestamp = binary.BigEndian.Uint32(data)
+	default:
+		return nil, errInvalidUserControlEvent
+	}
+	return e, nil
 }
 
-func GenerateUserStreamBegin(streamID uint32) ([]byte, error) {
-	var (
-		eventType  uint16 = 0
-		messageLen uint32 = 6
-	)
-
-	ch := generateUserControlMessageHeader(messageLen)
-	x, err := genChunkHeader(ch)
-	if err != nil {
-		return []byte{}, err
+// Bytes returns the payload of a User Control Message.
+func (e *UserControlEvent) Bytes() []byte {
+	var y []byte
+	switch e.Type {
+	case UserControlSetBufferLength:
+		y = make([]byte, 10)
+		binary.BigEndian.PutUint32(y[2:6], e.StreamID)
+		binary.BigEndian.PutUint32(y[6:], e.BufferLength)
+	case UserControlPingRequest, UserControlPingResponse:
+		y = make([]byte, 6)
+		binary.BigEndian.PutUint32(y[2:], e.Timestamp)
+	default:
+		y = make([]byte, 6)
+		binary.BigEndian.PutUint32(y[2:], e.StreamID)
 	}
+	binary.BigEndian.PutUint16(y[:2], uint16(e.Type))
+	return y
+}
+
+// User control messages are sent on the chunk stream ID 2 and the message stream ID 0.
+func newUserControlMessage(e *UserControlEvent) *Message {
+	return &Message{
+		ChunkStreamID: 2,
+		TypeID:        MessageUserControl,
+		StreamID:      0,
+		Payload:       e.Bytes(),
+	}
+}
+
+func GenerateUserControlEvent(e *UserControlEvent) ([]byte, error) {
+	return encodeMessage(newUserControlMessage(e))
+}
+
+func userStreamBeginMessage(streamID uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlStreamBegin, StreamID: streamID})
+}
+
+func GenerateUserStreamBegin(streamID uint32) ([]byte, error) {
+	return encodeMessage(userStreamBeginMessage(streamID))
+}
+
+func userStreamEOFMessage(streamID uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlStreamEOF, StreamID: streamID})
+}
+
+func GenerateUserStreamEOF(streamID uint32) ([]byte, error) {
+	return encodeMessage(userStreamEOFMessage(streamID))
+}
+
+func userStreamDryMessage(streamID uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlStreamDry, StreamID: streamID})
+}
+
+func GenerateUserStreamDry(streamID uint32) ([]byte, error) {
+	return encodeMessage(userStreamDryMessage(streamID))
+}
+
+func userSetBufferLengthMessage(streamID, bufferLength uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlSetBufferLength, StreamID: streamID, BufferLength: bufferLength})
+}
+
+func GenerateUserSetBufferLength(streamID, bufferLength uint32) ([]byte, error) {
+	return encodeMessage(userSetBufferLengthMessage(streamID, bufferLength))
+}
+
+func userStreamIsRecordedMessage(streamID uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlStreamIsRecorded, StreamID: streamID})
+}
+
+func GenerateUserStreamIsRecorded(streamID uint32) ([]byte, error) {
+	return encodeMessage(userStreamIsRecordedMessage(streamID))
+}
+
+func userPingRequestMessage(timestamp uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlPingRequest, Timestamp: timestamp})
+}
+
+func GenerateUserPingRequest(timestamp uint32) ([]byte, error) {
+	return encodeMessage(userPingRequestMessage(timestamp))
+}
+
+func userPingResponseMessage(timestamp uint32) *Message {
+	return newUserControlMessage(&UserControlEvent{Type: UserControlPingResponse, Timestamp: timestamp})
+}
 
-	y := make([]byte, messageLen)
-	binary.BigEndian.PutUint16(y[:2], eventType)
-	binary.BigEndian.PutUint32(y[2:], streamID)
-	return append(x, y...), nil
+func GenerateUserPingResponse(timestamp uint32) ([]byte, error) {
+	return encodeMessage(userPingResponseMessage(timestamp))
 }
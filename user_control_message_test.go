@@ -2,6 +2,7 @@ package rtmp
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -17,3 +18,54 @@ func TestGenerateUserStreamBegin(t *testing.T) {
 		t.Errorf("should be %#v, but got %#v", expected, actual)
 	}
 }
+
+func TestGenerateUserPingRequest(t *testing.T) {
+	expected := []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x30, 0x39,
+	}
+	actual, err := GenerateUserPingRequest(12345)
+	if err != nil {
+		t.Errorf("should be nil, but got %s", err)
+	}
+	if bytes.Compare(expected, actual) != 0 {
+		t.Errorf("should be %#v, but got %#v", expected, actual)
+	}
+}
+
+func TestGenerateUserSetBufferLength(t *testing.T) {
+	expected := []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a, 0x04, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x0b, 0xb8,
+	}
+	actual, err := GenerateUserSetBufferLength(1, 3000)
+	if err != nil {
+		t.Errorf("should be nil, but got %s", err)
+	}
+	if bytes.Compare(expected, actual) != 0 {
+		t.Errorf("should be %#v, but got %#v", expected, actual)
+	}
+}
+
+func TestParseUserControlEvent(t *testing.T) {
+	for _, expected := range []*UserControlEvent{
+		{Type: UserControlStreamBegin, StreamID: 1},
+		{Type: UserControlStreamEOF, StreamID: 1},
+		{Type: UserControlStreamDry, StreamID: 1},
+		{Type: UserControlSetBufferLength, StreamID: 1, BufferLength: 3000},
+		{Type: UserControlStreamIsRecorded, StreamID: 1},
+		{Type: UserControlPingRequest, Timestamp: 12345},
+		{Type: UserControlPingResponse, Timestamp: 12345},
+	} {
+		actual, err := ParseUserControlEvent(expected.Bytes())
+		if err != nil {
+			t.Errorf("should be nil, but got %s", err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("should be %#v, but got %#v", expected, actual)
+		}
+	}
+
+	if _, err := ParseUserControlEvent([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x01}); err != errInvalidUserControlEvent {
+		t.Errorf("should be %s, but got %v", errInvalidUserControlEvent, err)
+	}
+}
@@ -0,0 +1,504 @@
+package rtmp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MediaReader reads the audio, video and data messages of a recorded stream in timestamp order.
+type MediaReader interface {
+	// ReadMessage returns the next message. It returns io.EOF at the end of the stream.
+	ReadMessage() (*Message, error)
+	Close() error
+}
+
+// A MediaSeeker is a MediaReader which supports the seek command.
+type MediaSeeker interface {
+	MediaReader
+	// Seek moves to the nearest keyframe at or before the time in milliseconds,
+	// and returns the timestamp of the keyframe.
+	Seek(ms uint32) (uint32, error)
+}
+
+var errNotSeekable = errors.New("not seekable")
+
+// An OnDemandSource opens the recorded streams played by the play command.
+type OnDemandSource interface {
+	// Open opens the recorded stream of the app.
+	// It returns an error which wraps fs.ErrNotExist if there is no such stream.
+	Open(app, streamName string) (MediaReader, error)
+}
+
+// FileSource is an OnDemandSource which serves the FLV and MP4 files in a file system.
+// The files with the extensions .mp4, .m4v, .m4a, .mov and .f4v are read as MP4,
+// and the others as FLV.
+//
+// The files are seekable if the file system returns files implementing io.Seeker like os.DirFS.
+// MP4 files can be played only if they are seekable, and are seeked through the sync samples.
+// The keyframe index of an FLV file is read from onMetaData, or built by scanning the file if onMetaData
+// doesn't have it, when the file is opened first. It is kept until the file is modified.
+type FileSource struct {
+	// FS is the file system which has the files, e.g. os.DirFS("/var/lib/rtmp").
+	// The paths outside FS can't be opened.
+	FS fs.FS
+	// Path maps the app and the stream name to the path of the file in FS.
+	// If nil, DefaultFilePath is used.
+	Path func(app, streamName string) string
+
+	mu      sync.Mutex
+	indexes map[string]*fileIndex // by path.
+}
+
+// fileIndex is the keyframe index of the file at the modification time and the size.
+type fileIndex struct {
+	modTime time.Time
+	size    int64
+	index   flvIndex
+}
+
+// DefaultFilePath maps the app and the stream name to "<app>/<stream name>.flv".
+// The "flv:" prefix of the stream name is removed, and the "mp4:" prefix is removed
+// with the ".mp4" extension instead of ".flv". The extension is not added if the stream name has it.
+// The path is not cleaned, so that the stream names with ".." elements or a leading slash, which
+// would open the files outside of the app, result in an invalid path rejected by FileSource.
+func DefaultFilePath(app, streamName string) string {
+	name, ext := streamName, ".flv"
+	if strings.HasPrefix(name, "flv:") {
+		name = name[len("flv:"):]
+	} else if strings.HasPrefix(name, "mp4:") {
+		name, ext = name[len("mp4:"):], ".mp4"
+	}
+	if path.Ext(name) == "" {
+		name += ext
+	}
+	return app + "/" + name
+}
+
+// isMP4 reports whether the file is MP4 by the extension.
+func isMP4(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4", ".m4v", ".m4a", ".mov", ".f4v":
+		return true
+	}
+	return false
+}
+
+// Open implements OnDemandSource.
+func (s *FileSource) Open(app, streamName string) (MediaReader, error) {
+	mapping := s.Path
+	if mapping == nil {
+		mapping = DefaultFilePath
+	}
+	name := mapping(app, streamName)
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if isMP4(name) {
+		return openMP4(f)
+	}
+	index, err := s.index(name, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fr, err := NewFLVReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &flvFile{FLVReader: fr, f: f, index: index}, nil
+}
+
+// index returns the keyframe index of the file, which is rewound to the start.
+// It returns nil if the file can't seek.
+func (s *FileSource) index(name string, f fs.File) (flvIndex, error) {
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		return nil, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	cached := s.indexes[name]
+	s.mu.Unlock()
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.index, nil
+	}
+
+	index, err := readFLVIndex(rs)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.indexes == nil {
+		s.indexes = make(map[string]*fileIndex)
+	}
+	s.indexes[name] = &fileIndex{modTime: info.ModTime(), size: info.Size(), index: index}
+	s.mu.Unlock()
+	return index, nil
+}
+
+func openMP4(f fs.File) (MediaReader, error) {
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		f.Close()
+		return nil, errNotSeekable
+	}
+	mr, err := NewMP4Reader(rs)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &mp4File{MP4Reader: mr, f: f}, nil
+}
+
+// mp4File is the MediaReader of the MP4 file.
+type mp4File struct {
+	*MP4Reader
+	f fs.File
+}
+
+func (f *mp4File) Close() error {
+	return f.f.Close()
+}
+
+// flvFile is the MediaReader of the FLV file.
+type flvFile struct {
+	*FLVReader
+	f     fs.File
+	index flvIndex // empty if the file can't seek or has no keyframes.
+	// pending has onMetaData and the sequence headers which are read before the keyframe sought,
+	// since the player needs the decoder configurations at the start of the file.
+	pending []*Message
+}
+
+// ReadMessage returns the pending messages and then the next tag.
+func (f *flvFile) ReadMessage() (*Message, error) {
+	if len(f.pending) > 0 {
+		msg := f.pending[0]
+		f.pending = f.pending[1:]
+		return msg, nil
+	}
+	return f.FLVReader.ReadMessage()
+}
+
+// Seek implements MediaSeeker.
+func (f *flvFile) Seek(ms uint32) (uint32, error) {
+	rs, ok := f.f.(io.ReadSeeker)
+	if !ok || len(f.index) == 0 {
+		return 0, errNotSeekable
+	}
+	headers, err := readFLVHeaderTags(rs)
+	if err != nil {
+		return 0, err
+	}
+	keyframe := f.index.lookup(ms)
+	if _, err = rs.Seek(keyframe.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	// The headers are sent at the time of the keyframe.
+	f.pending = f.pending[:0]
+	for _, msg := range headers {
+		msg.Timestamp = keyframe.timestamp
+		f.pending = append(f.pending, msg)
+	}
+	return keyframe.timestamp, nil
+}
+
+func (f *flvFile) Close() error {
+	return f.f.Close()
+}
+
+// vodPlayer sends the messages of the recorded stream to the peer paced in real time.
+// The seek and pause commands are passed to the goroutine running the player
+// so that the status events are sent in order with the media.
+type vodPlayer struct {
+	c        *conn
+	streamID uint32
+	name     string
+	r        MediaReader
+	ctrl     chan vodControl
+	stop     chan struct{}
+	done     chan struct{}
+
+	// The following fields are accessed only by the goroutine running the player.
+	started  bool
+	start    time.Time // when the message of the timestamp first is sent.
+	first    uint32
+	position uint32 // the timestamp of the last message.
+	paused   bool
+	end      int64    // the timestamp after which the player stops. -1 means until the end.
+	pending  *Message // the message waiting to be sent. nil after seeking.
+}
+
+// vodControl is the seek or pause command.
+type vodControl struct {
+	seek  bool
+	ms    uint32 // the time to seek to, or to resume from when unpausing.
+	pause bool   // pause or unpause unless seek.
+}
+
+func newVODPlayer(c *conn, streamID uint32, name string, r MediaReader) *vodPlayer {
+	return &vodPlayer{
+		c:        c,
+		streamID: streamID,
+		name:     name,
+		r:        r,
+		ctrl:     make(chan vodControl),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		end:      -1,
+	}
+}
+
+// startAt seeks to the start time of the play command, and stops the player after the duration.
+// The stream is played from the beginning if the start time is negative or it can't seek.
+// It should be called before run.
+func (p *vodPlayer) startAt(start, duration float64) {
+	from := 0.0
+	if start > 0 {
+		if s, ok := p.r.(MediaSeeker); !ok {
+			p.c.log().Info("Play the recorded stream from the beginning", "start", start, "error", errNotSeekable)
+		} else if _, err := s.Seek(uint32(start)); err != nil {
+			p.c.log().Info("Play the recorded stream from the beginning", "start", start, "error", err)
+		} else {
+			from = start
+		}
+	}
+	if duration >= 0 {
+		p.end = int64(from + duration)
+	}
+}
+
+func (p *vodPlayer) run() {
+	defer close(p.done)
+	defer p.r.Close()
+
+	for {
+		if p.paused {
+			select {
+			case ctl := <-p.ctrl:
+				if !p.apply(ctl) {
+					return
+				}
+			case <-p.stop:
+				return
+			}
+			continue
+		}
+
+		if p.pending == nil {
+			msg, err := p.r.ReadMessage()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				p.c.log().Error("Read recorded stream error", "error", err)
+				break
+			}
+			if msg.TypeID != MessageAudio && msg.TypeID != MessageVideo && msg.TypeID != MessageDataAMF0 {
+				continue
+			}
+			if p.end >= 0 && int64(msg.Timestamp) > p.end {
+				break
+			}
+			// The clock starts at the first frame, since onMetaData and the sequence headers
+			// before it may have an earlier timestamp, e.g. 0 after seeking to the start time.
+			if !p.started && isMediaFrame(msg) {
+				p.rebase(msg.Timestamp)
+			}
+			p.pending = msg
+		}
+
+		ctl, stopped := p.wait(p.start.Add(time.Duration(int32(p.pending.Timestamp-p.first)) * time.Millisecond))
+		if stopped {
+			return
+		} else if ctl != nil {
+			if !p.apply(*ctl) {
+				return
+			}
+			continue
+		}
+		if !p.write(playMessage(p.streamID, p.pending)) {
+			return
+		}
+		p.position = p.pending.Timestamp
+		p.pending = nil
+	}
+
+	p.c.log().Info("Finish playing the recorded stream")
+	p.c.setState(StateSentCreateStreamResponse)
+	err := p.c.writeMessages(
+		userStreamEOFMessage(p.streamID),
+		onStatusMessage(p.streamID, CommandLevelStatus, CodeNetStreamPlayStop,
+			"Stopped playing "+p.name+"."),
+	)
+	if err != nil {
+		p.c.log().Error("Write StreamEOF error", "error", err)
+	}
+}
+
+// isMediaFrame reports whether the message is an audio or video frame, not a sequence header.
+func isMediaFrame(msg *Message) bool {
+	switch msg.TypeID {
+	case MessageVideo:
+		return !isVideoSequenceHeader(msg.Payload)
+	case MessageAudio:
+		return !isAudioSequenceHeader(msg.Payload)
+	}
+	return false
+}
+
+// rebase makes the message of the timestamp sent now.
+func (p *vodPlayer) rebase(timestamp uint32) {
+	p.started = true
+	p.start = time.Now()
+	p.first = timestamp
+	p.position = timestamp
+}
+
+// wait waits until t. It returns the command received while waiting,
+// or stopped is true if the player is stopped.
+func (p *vodPlayer) wait(t time.Time) (ctl *vodControl, stopped bool) {
+	d := time.Until(t)
+	if d <= 0 {
+		select {
+		case c := <-p.ctrl:
+			return &c, false
+		case <-p.stop:
+			return nil, true
+		default:
+			return nil, false
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, false
+	case c := <-p.ctrl:
+		return &c, false
+	case <-p.stop:
+		return nil, true
+	}
+}
+
+// apply applies the command. It returns false if the connection is broken.
+func (p *vodPlayer) apply(ctl vodControl) bool {
+	switch {
+	case ctl.seek:
+		s, ok := p.r.(MediaSeeker)
+		if !ok {
+			return p.seekFailed(ctl.ms, errNotSeekable)
+		}
+		timestamp, err := s.Seek(ctl.ms)
+		if err != nil {
+			return p.seekFailed(ctl.ms, err)
+		}
+		p.c.log().Info("Seek the recorded stream", "ms", ctl.ms, "timestamp", timestamp)
+		p.rebase(timestamp)
+		p.pending = nil
+		return p.write(
+			userStreamBeginMessage(p.streamID),
+			onStatusMessage(p.streamID, CommandLevelStatus, CodeNetStreamSeekNotify,
+				fmt.Sprintf("Seeking %d (stream ID: %d).", ctl.ms, p.streamID)),
+		)
+	case ctl.pause:
+		if p.paused {
+			return true
+		}
+		p.paused = true
+		p.c.log().Info("Pause the recorded stream", "position", p.position)
+		return p.write(
+			userStreamEOFMessage(p.streamID),
+			onStatusMessage(p.streamID, CommandLevelStatus, CodeNetStreamPauseNotify,
+				"Pausing "+p.name+"."),
+		)
+	default:
+		if !p.paused {
+			return true
+		}
+		p.paused = false
+		p.resume(ctl.ms)
+		p.c.log().Info("Unpause the recorded stream", "ms", ctl.ms, "position", p.position)
+		return p.write(
+			userStreamBeginMessage(p.streamID),
+			onStatusMessage(p.streamID, CommandLevelStatus, CodeNetStreamUnpauseNotify,
+				"Unpausing "+p.name+"."),
+		)
+	}
+}
+
+// resume makes the player resume from the position sent by the peer. It resumes from the last message
+// sent before pausing if the stream can't seek.
+func (p *vodPlayer) resume(ms uint32) {
+	if s, ok := p.r.(MediaSeeker); ok {
+		timestamp, err := s.Seek(ms)
+		if err == nil {
+			p.rebase(timestamp)
+			p.pending = nil
+			return
+		}
+		p.c.log().Info("Seek error", "ms", ms, "error", err)
+	}
+	// Resume from the last message as if it was sent now.
+	p.start = time.Now()
+	p.first = p.position
+}
+
+func (p *vodPlayer) seekFailed(ms uint32, err error) bool {
+	p.c.log().Info("Seek error", "ms", ms, "error", err)
+	return p.write(onStatusMessage(p.streamID, CommandLevelError, CodeNetStreamSeekFailed,
+		fmt.Sprintf("Failed to seek %s.", p.name)))
+}
+
+// write writes the messages. It returns false and closes the connection if it fails.
+func (p *vodPlayer) write(msgs ...*Message) bool {
+	if err := p.c.writeMessages(msgs...); err != nil {
+		p.c.log().Error("Write media error", "error", err)
+		p.c.netconn.Close()
+		return false
+	}
+	return true
+}
+
+// seek seeks the recorded stream to the nearest keyframe at or before ms.
+// It returns false if the player is finished.
+func (p *vodPlayer) seek(ms uint32) bool {
+	return p.control(vodControl{seek: true, ms: ms})
+}
+
+// pause pauses or unpauses the player, which resumes from ms. It returns false if the player is finished.
+// It does nothing if the player is already paused or unpaused.
+func (p *vodPlayer) pause(pause bool, ms uint32) bool {
+	return p.control(vodControl{pause: pause, ms: ms})
+}
+
+func (p *vodPlayer) control(ctl vodControl) bool {
+	select {
+	case p.ctrl <- ctl:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// close stops the player.
+func (p *vodPlayer) close() {
+	close(p.stop)
+}
@@ -0,0 +1,57 @@
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDefaultFilePath(t *testing.T) {
+	for _, x := range []struct {
+		app, name string
+		expected  string
+	}{
+		{"vod", "movie", "vod/movie.flv"},
+		{"vod", "flv:movie", "vod/movie.flv"},
+		{"vod", "movie.flv", "vod/movie.flv"},
+		{"vod", "mp4:movie", "vod/movie.mp4"},
+		{"vod", "mp4:movie.m4v", "vod/movie.m4v"},
+		{"vod", "movie.mp4", "vod/movie.mp4"},
+		{"vod", "../private/secret", "vod/../private/secret.flv"},
+		{"vod", "/etc/secret", "vod//etc/secret.flv"},
+	} {
+		if actual := DefaultFilePath(x.app, x.name); actual != x.expected {
+			t.Errorf("Should be %#v, but got %#v", x.expected, actual)
+		}
+	}
+}
+
+func TestFileSourceOpen(t *testing.T) {
+	flv := new(bytes.Buffer)
+	NewFLVWriter(flv).WriteTag(MessageVideo, 0, []byte{0x17, 0x00})
+	source := &FileSource{FS: fstest.MapFS{"vod/movie.flv": &fstest.MapFile{Data: flv.Bytes()}}}
+
+	r, err := source.Open("vod", "movie")
+	if err != nil {
+		t.Fatalf("Should be nil, but got %s", err)
+	}
+	defer r.Close()
+	if msg, err := r.ReadMessage(); err != nil || msg.TypeID != MessageVideo {
+		t.Errorf("Should be a video message, but got %#v, %v", msg, err)
+	}
+
+	if _, err = source.Open("live", "movie"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Should be %s, but got %v", fs.ErrNotExist, err)
+	}
+	for _, name := range []string{"../vod/movie", "x/../movie", "/movie"} {
+		if _, err = source.Open("vod", name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Should be %s, but got %v for %#v", fs.ErrNotExist, err, name)
+		}
+	}
+	source.Path = func(app, name string) string { return "../" + name }
+	if _, err = source.Open("vod", "movie"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Should be %s, but got %v", fs.ErrNotExist, err)
+	}
+}